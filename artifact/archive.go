@@ -0,0 +1,189 @@
+package artifact
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultModTime is the modification time of every archived file, unless
+// SOURCE_DATE_EPOCH is set. Zip can't store times before 1980.
+var DefaultModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ModTime returns the time used for archived files, honoring
+// SOURCE_DATE_EPOCH (https://reproducible-builds.org/specs/source-date-epoch/)
+func ModTime() (time.Time, error) {
+	epoch := os.Getenv("SOURCE_DATE_EPOCH")
+	if epoch == "" {
+		return DefaultModTime, nil
+	}
+
+	secs, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %w", epoch, err)
+	}
+
+	t := time.Unix(secs, 0).UTC()
+	if t.Before(DefaultModTime) {
+		t = DefaultModTime
+	}
+
+	return t, nil
+}
+
+// File is a single file put into an archive
+type File struct {
+	Path string // slash separated path inside the archive
+	Src  string // file on disk
+}
+
+// Options control the layout of archives, files are stored under Prefix
+type Options struct {
+	Prefix  string
+	ModTime time.Time
+}
+
+// sorted returns the files ordered by their path in the archive, so the
+// archive does not depend on the order files were found in
+func sorted(files []File) []File {
+	files = slices.Clone(files)
+	slices.SortFunc(files, func(a, b File) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+
+	return files
+}
+
+// mode normalizes permissions, only the executable bit is kept
+func mode(info os.FileInfo) int64 {
+	if info.Mode()&0111 != 0 {
+		return 0755
+	}
+
+	return 0644
+}
+
+// WriteTarGz writes a reproducible gzip compressed tar archive, file owners,
+// times and permissions are normalized
+func WriteTarGz(w io.Writer, files []File, opts Options) error {
+	gz, err := gzip.NewWriterLevel(w, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(gz)
+	for _, f := range sorted(files) {
+		info, err := os.Stat(f.Src)
+		if err != nil {
+			return err
+		}
+
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(opts.Prefix, f.Path),
+			Mode:     mode(info),
+			Size:     info.Size(),
+			ModTime:  opts.ModTime,
+			Format:   tar.FormatPAX,
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if err := copyFile(tw, f.Src); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// WriteZip writes a reproducible zip archive
+func WriteZip(w io.Writer, files []File, opts Options) error {
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, flate.BestCompression)
+	})
+
+	for _, f := range sorted(files) {
+		info, err := os.Stat(f.Src)
+		if err != nil {
+			return err
+		}
+
+		hdr := &zip.FileHeader{
+			Name:     path.Join(opts.Prefix, f.Path),
+			Method:   zip.Deflate,
+			Modified: opts.ModTime,
+		}
+		hdr.SetMode(os.FileMode(mode(info)))
+
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		if err := copyFile(fw, f.Src); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func copyFile(w io.Writer, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Create writes an archive to path through a temporary file, the format is
+// picked from the extension
+func Create(dst string, files []File, opts Options) error {
+	var write func(io.Writer, []File, Options) error
+	switch {
+	case strings.HasSuffix(dst, ".zip"):
+		write = WriteZip
+	case strings.HasSuffix(dst, ".tar.gz"):
+		write = WriteTarGz
+	default:
+		return fmt.Errorf("unknown archive format of %s, expected .tar.gz or .zip", dst)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".krill-archive-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp, files, opts); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,140 @@
+package artifact
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ChecksumsFile lists the SHA-256 of every archive in a directory, in the
+// format of sha256sum
+const ChecksumsFile = "checksums.txt"
+
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReadChecksums reads the checksums file of dir, keyed by file name
+func ReadChecksums(dir string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(dir, ChecksumsFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+
+		sum, name, ok := strings.Cut(text, " ")
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		if !ok || len(sum) != sha256.Size*2 || name == "" {
+			return nil, fmt.Errorf("%s:%d: invalid line %q", ChecksumsFile, line, text)
+		}
+
+		sums[name] = strings.ToLower(sum)
+	}
+
+	return sums, sc.Err()
+}
+
+// UpdateChecksums records the checksums of the given files in dir, keeping
+// the entries of other files that still exist
+func UpdateChecksums(dir string, names []string) error {
+	sums, err := ReadChecksums(dir)
+	if os.IsNotExist(err) {
+		sums = make(map[string]string)
+	} else if err != nil {
+		return err
+	}
+
+	for name := range sums {
+		if _, err := os.Stat(filepath.Join(dir, name)); os.IsNotExist(err) {
+			delete(sums, name)
+		}
+	}
+
+	for _, name := range names {
+		sum, err := HashFile(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+
+		sums[name] = sum
+	}
+
+	keys := make([]string, 0, len(sums))
+	for k := range sums {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var sb strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%s  %s\n", sums[k], k)
+	}
+
+	return os.WriteFile(filepath.Join(dir, ChecksumsFile), []byte(sb.String()), 0644)
+}
+
+// Mismatch is a file that failed verification
+type Mismatch struct {
+	Name   string
+	Reason string
+}
+
+// Verify checks every file listed in the checksums file of dir
+func Verify(dir string) (ok []string, failed []Mismatch, err error) {
+	sums, err := ReadChecksums(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(sums))
+	for k := range sums {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		if !filepath.IsLocal(name) {
+			failed = append(failed, Mismatch{name, "path is outside of the directory"})
+			continue
+		}
+
+		sum, err := HashFile(filepath.Join(dir, name))
+		switch {
+		case os.IsNotExist(err):
+			failed = append(failed, Mismatch{name, "missing"})
+		case err != nil:
+			return ok, failed, err
+		case sum != sums[name]:
+			failed = append(failed, Mismatch{name, fmt.Sprintf("checksum mismatch, expected %s got %s", sums[name], sum)})
+		default:
+			ok = append(ok, name)
+		}
+	}
+
+	return ok, failed, nil
+}
@@ -0,0 +1,171 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kociumba/krill/cli_utils"
+	"github.com/kociumba/krill/config"
+)
+
+type CleanOptions struct {
+	Targets []string // targets to clean together with their dependencies, all if empty
+	DryRun  bool
+}
+
+// Clean removes the output_dir and outputs of targets and runs their clean
+// commands, paths outside of the project are never removed
+func Clean(ctx context.Context, cfg *config.Cfg, opts CleanOptions) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	p := newGraph(cfg, wd)
+	p.lenient = true
+	if err := p.addAll(cfg, wd); err != nil {
+		return err
+	}
+
+	nodes := p.order
+	if len(opts.Targets) > 0 {
+		selected := make(map[*node]bool)
+		for _, name := range opts.Targets {
+			n, err := p.find(name)
+			if err != nil {
+				return err
+			}
+
+			for d := range reachable(n, func(n *node) []*node { return n.deps }) {
+				selected[d] = true
+			}
+		}
+
+		nodes = filterNodes(p.order, func(n *node) bool { return selected[n] })
+	}
+
+	removed := 0
+	for _, n := range nodes {
+		if len(n.target.Clean) > 0 && n.target.Supported() {
+			if err := p.cleanCommands(ctx, n, opts.DryRun); err != nil {
+				return fmt.Errorf("%s: %w", p.label(n), err)
+			}
+		}
+
+		paths, err := p.cleanPaths(n)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.label(n), err)
+		}
+
+		for _, path := range paths {
+			rel, _ := filepath.Rel(wd, path)
+			if opts.DryRun {
+				fmt.Println("Would remove:", rel)
+				continue
+			}
+
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", rel, err)
+			}
+
+			fmt.Println("Removed:", rel)
+			removed++
+		}
+
+		if !opts.DryRun {
+			p.state.forget(n.dir, n.name)
+		}
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	if err := p.state.save(); err != nil {
+		return err
+	}
+
+	if removed == 0 {
+		cli_utils.PrintInfoMessage("Nothing to clean")
+	}
+
+	return nil
+}
+
+func (p *plan) cleanCommands(ctx context.Context, n *node, dryRun bool) error {
+	if dryRun {
+		for _, cmd := range n.target.Clean {
+			fmt.Printf("Would run clean: %s\n", cmd)
+		}
+
+		return nil
+	}
+
+	if err := p.ensureEnv(n.cfg, n.dir); err != nil {
+		return err
+	}
+
+	env, err := n.resolveEnv()
+	if err != nil {
+		return err
+	}
+
+	n.env = env
+	return p.runCommands(ctx, n, nil, "clean", n.target.Clean)
+}
+
+// cleanPaths lists the existing output_dir and outputs of a target, all of
+// them have to be inside the root project and can't contain a project
+func (p *plan) cleanPaths(n *node) ([]string, error) {
+	var paths []string
+	if n.target.OutputDir != "" {
+		dir := resolvePath(n.dir, n.target.OutputDir)
+		if _, err := os.Stat(dir); err == nil {
+			paths = append(paths, dir)
+		}
+	}
+
+	files, err := expandGlobs(n.dir, n.target.Outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		path := filepath.Join(n.dir, filepath.FromSlash(f))
+		if !slices.ContainsFunc(paths, func(dir string) bool { return within(dir, path) }) {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		if !within(p.dir, path) || path == p.dir {
+			return nil, fmt.Errorf("refusing to remove %s, it is outside of the project %s", path, p.dir)
+		}
+
+		for dir := range p.configs {
+			if within(path, dir) {
+				return nil, fmt.Errorf("refusing to remove %s, it contains the project %s", path, dir)
+			}
+		}
+
+		if base := filepath.Base(path); base == ".git" || base == stateDir {
+			return nil, fmt.Errorf("refusing to remove %s", path)
+		}
+	}
+
+	return paths, nil
+}
+
+// within reports whether path is dir or inside of it
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel))
+}
@@ -0,0 +1,123 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kociumba/krill/cli_utils"
+	"github.com/kociumba/krill/config"
+)
+
+// dryRun prints what executing the plan would do, in the order targets would
+// be started with a single job, without running commands or writing files
+func (p *plan) dryRun() error {
+	cli_utils.PrintHeader(fmt.Sprintf("Plan for %s (%d targets)", p.rootLabels(), len(p.order)), cli_utils.ColorCyan)
+
+	for i, n := range p.order {
+		env, err := n.resolveEnv()
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.label(n), err)
+		}
+
+		n.env = env
+
+		fmt.Println()
+		cli_utils.PrintColoredLine(fmt.Sprintf("[%d] %s", i+1, p.label(n)), cli_utils.ColorCyan)
+
+		if len(n.deps) > 0 {
+			deps := make([]string, len(n.deps))
+			for j, d := range n.deps {
+				deps[j] = p.label(d)
+			}
+
+			fmt.Printf("    after:   %s\n", strings.Join(deps, ", "))
+		}
+
+		fmt.Printf("    project: %s\n", n.dir)
+
+		if !n.target.Supported() {
+			cli_utils.PrintColoredLine(fmt.Sprintf("    (skipped, only runs on %s)", n.target.PlatformDescription()), cli_utils.ColorGray)
+			continue
+		}
+
+		if n.target.OutputDir != "" {
+			outputPath := filepath.Join(n.dir, n.target.OutputDir)
+			if _, err := os.Stat(outputPath); os.IsNotExist(err) {
+				fmt.Printf("    creates: %s\n", outputPath)
+			}
+
+			gitignorePath := filepath.Join(outputPath, ".gitignore")
+			if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
+				fmt.Printf("    creates: %s\n", gitignorePath)
+			}
+		}
+
+		if len(n.env) > 0 {
+			fmt.Printf("    env:     %s\n", strings.Join(sortedKeys(n.env), ", "))
+		}
+
+		if !n.target.HasCommands() {
+			cli_utils.PrintColoredLine("    (no commands)", cli_utils.ColorGray)
+			continue
+		}
+
+		for _, hook := range n.target.Hooks() {
+			if hook.Stage != "" && len(hook.Commands) > 0 {
+				fmt.Printf("    %s:\n", hook.Stage)
+			}
+
+			for _, cmd := range hook.Commands {
+				if err := p.dryRunCommand(n, cmd); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	fmt.Println()
+	return nil
+}
+
+func (p *plan) dryRunCommand(n *node, cmd config.Command) error {
+	inv := n.invocation(cmd)
+
+	ok, err := n.when(cmd)
+	if err != nil {
+		return fmt.Errorf("%s: %w", p.label(n), err)
+	}
+
+	if !ok {
+		cli_utils.PrintColoredLine(fmt.Sprintf("    $ %s (skipped, when %s)", cmd.Run, cmd.When), cli_utils.ColorGray)
+		return nil
+	}
+
+	fmt.Printf("    $ %s\n", cmd.Run)
+	cli_utils.PrintColoredLine(fmt.Sprintf("      in   %s", inv.dir), cli_utils.ColorGray)
+	cli_utils.PrintColoredLine(fmt.Sprintf("      exec %s", quoteArgs(append([]string{inv.shell}, inv.args...))), cli_utils.ColorGray)
+
+	if len(cmd.Env) > 0 {
+		cli_utils.PrintColoredLine(fmt.Sprintf("      env  %s", strings.Join(sortedKeys(cmd.Env), ", ")), cli_utils.ColorGray)
+	}
+
+	if cmd.IgnoreError {
+		cli_utils.PrintColoredLine("      failure is ignored", cli_utils.ColorGray)
+	}
+
+	return nil
+}
+
+func quoteArgs(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$&|;<>()*?") {
+			quoted[i] = strconv.Quote(arg)
+		} else {
+			quoted[i] = arg
+		}
+	}
+
+	return strings.Join(quoted, " ")
+}
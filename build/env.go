@@ -0,0 +1,144 @@
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/kociumba/krill/config"
+)
+
+// resolveEnv computes the variables a target sets on top of the inherited
+// process environment, later sources override earlier ones: env_files of the
+// environment, env of the environment, env_files of the target, env of the
+// target, and finally the path_prepend entries of the target and environment
+func (n *node) resolveEnv() (map[string]string, error) {
+	env := n.cfg.Env[runtime.GOOS]
+	vars := make(map[string]string)
+
+	lookup := func(key string) (string, bool) {
+		if v, ok := vars[envKey(key)]; ok {
+			return v, true
+		}
+
+		return os.LookupEnv(key)
+	}
+
+	layers := []struct {
+		files []string
+		env   map[string]string
+	}{
+		{env.EnvFiles, env.Env},
+		{n.target.EnvFiles, n.target.Env},
+	}
+
+	for _, layer := range layers {
+		for _, file := range layer.files {
+			fileVars, err := loadEnvFile(filepath.Join(n.dir, file), lookup)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, k := range sortedKeys(fileVars) {
+				vars[envKey(k)] = fileVars[k]
+			}
+		}
+
+		for _, k := range sortedKeys(layer.env) {
+			vars[envKey(k)] = layer.env[k]
+		}
+	}
+
+	prepend := append(slices.Clone(n.target.PathPrepend), env.PathPrepend...)
+	if len(prepend) > 0 {
+		entries := make([]string, 0, len(prepend)+1)
+		for _, p := range prepend {
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(n.dir, p)
+			}
+
+			entries = append(entries, p)
+		}
+
+		if cur, ok := lookup("PATH"); ok && cur != "" {
+			entries = append(entries, cur)
+		}
+
+		vars[envKey("PATH")] = strings.Join(entries, string(os.PathListSeparator))
+	}
+
+	return vars, nil
+}
+
+// missing env files are skipped, so optional files like .env.local can be
+// listed without having to exist
+func loadEnvFile(path string, lookup func(string) (string, bool)) (map[string]string, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	vars, err := config.ParseDotenv(f, lookup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse env file %s: %w", path, err)
+	}
+
+	return vars, nil
+}
+
+// environ applies the resolved variables on top of the process environment,
+// the result is sorted so commands always see the same environment
+func environ(vars map[string]string) []string {
+	merged := make(map[string]string)
+	names := make(map[string]string)
+	for _, kv := range os.Environ() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			continue
+		}
+
+		merged[envKey(k)] = v
+		names[envKey(k)] = k
+	}
+
+	for k, v := range vars {
+		merged[k] = v
+		if _, ok := names[k]; !ok {
+			names[k] = k
+		}
+	}
+
+	out := make([]string, 0, len(merged))
+	for _, k := range sortedKeys(merged) {
+		out = append(out, names[k]+"="+merged[k])
+	}
+
+	return out
+}
+
+// envKey normalizes variable names, which are case insensitive on windows
+func envKey(k string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(k)
+	}
+
+	return k
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,253 @@
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/kociumba/krill/cli_utils"
+	"github.com/kociumba/krill/config"
+)
+
+// runTarget runs pre, the commands and post of a target, stopping at the
+// first failure. on_failure and finally run even if the run was interrupted,
+// their commands are not cancelled.
+func (p *plan) runTarget(ctx context.Context, n *node, rec *TargetRecord) (err error) {
+	defer func() {
+		cleanup := context.WithoutCancel(ctx)
+		if err != nil && len(n.target.OnFailure) > 0 {
+			if herr := p.runCommands(cleanup, n, rec, "on_failure", n.target.OnFailure); herr != nil {
+				cli_utils.PrintWarningMessage(herr.Error())
+			}
+		}
+
+		if len(n.target.Finally) > 0 {
+			if herr := p.runCommands(cleanup, n, rec, "finally", n.target.Finally); herr != nil {
+				if err == nil {
+					err = herr
+				} else {
+					cli_utils.PrintWarningMessage(herr.Error())
+				}
+			}
+		}
+	}()
+
+	if n.target.OutputDir != "" {
+		outputPath := filepath.Join(n.dir, n.target.OutputDir)
+		if err := os.MkdirAll(outputPath, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory %q: %w", n.target.OutputDir, err)
+		}
+
+		gitignorePath := filepath.Join(outputPath, ".gitignore")
+		if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
+			if err := os.WriteFile(gitignorePath, []byte("*"), 0644); err != nil {
+				return fmt.Errorf("failed to write .gitignore in %q: %w", n.target.OutputDir, err)
+			}
+		}
+	}
+
+	if err := p.runCommands(ctx, n, rec, "pre", n.target.Pre); err != nil {
+		return err
+	}
+
+	if err := p.runCommands(ctx, n, rec, "", n.target.Commands); err != nil {
+		return err
+	}
+
+	return p.runCommands(ctx, n, rec, "post", n.target.Post)
+}
+
+// runCommands runs one command list of a target, hook is empty for the
+// commands of the target itself
+func (p *plan) runCommands(ctx context.Context, n *node, rec *TargetRecord, hook string, cmds []config.Command) error {
+	prefix := ""
+	if hook != "" {
+		prefix = hook + " "
+	}
+
+	for _, cmd := range cmds {
+		if ok, err := n.when(cmd); err != nil {
+			return err
+		} else if !ok {
+			fmt.Printf("Skipping: %s (when %s)\n", cmd, cmd.When)
+			continue
+		}
+
+		if hook != "" {
+			fmt.Printf("Running %s: %s\n", hook, cmd)
+		} else {
+			fmt.Println("Running:", cmd)
+		}
+
+		crec, err := p.runCommand(ctx, n, rec, hook, cmd)
+		if err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%scommand %q stopped: %w", prefix, cmd.Run, context.Cause(ctx))
+			}
+
+			if cmd.IgnoreError {
+				cli_utils.PrintWarningMessage(fmt.Sprintf("%scommand %q failed, ignoring: %v", prefix, cmd.Run, err))
+				continue
+			}
+
+			if crec != nil {
+				p.logs.failCommand(crec)
+			}
+
+			return fmt.Errorf("%scommand %q failed: %w", prefix, cmd.Run, err)
+		}
+	}
+
+	return nil
+}
+
+// runCommand runs a single command, teeing its output into the run log, quiet
+// targets only print the captured output if the command fails. The record of
+// the command is nil if it is not logged.
+func (p *plan) runCommand(ctx context.Context, n *node, rec *TargetRecord, hook string, cmd config.Command) (*CommandRecord, error) {
+	inv := n.invocation(cmd)
+	run := exec.CommandContext(ctx, inv.shell, inv.args...)
+	run.Dir = inv.dir
+	run.Env = environ(inv.env)
+	run.Stdin = os.Stdin
+	run.Stdout = os.Stdout
+	run.Stderr = os.Stderr
+	group := newProcessGroup(ctx, run, p.gracePeriod)
+
+	var sinks []io.Writer
+	var crec *CommandRecord
+	if p.logs != nil && rec != nil {
+		var logFile *os.File
+		var err error
+		crec, logFile, err = p.logs.startCommand(rec, cmd.Run, hook)
+		if err != nil {
+			cli_utils.PrintWarningMessage(fmt.Sprintf("could not create log file: %v", err))
+		} else if logFile != nil {
+			defer logFile.Close()
+			sinks = append(sinks, logFile)
+		}
+	}
+
+	quiet := n.target.Quiet != nil && *n.target.Quiet
+	var captured bytes.Buffer
+	if quiet {
+		sinks = append(sinks, &captured)
+	}
+
+	if len(sinks) > 0 {
+		shared := &syncWriter{w: io.MultiWriter(sinks...)}
+		if quiet {
+			run.Stdout, run.Stderr = shared, shared
+		} else {
+			run.Stdout = io.MultiWriter(os.Stdout, shared)
+			run.Stderr = io.MultiWriter(os.Stderr, shared)
+		}
+	}
+
+	err := group.run()
+	if crec != nil {
+		p.logs.finishCommand(crec, err)
+	}
+
+	if err != nil && quiet {
+		os.Stderr.Write(captured.Bytes())
+	}
+
+	return crec, err
+}
+
+// when evaluates the condition of a command, env values come from the
+// environment the command would run in
+func (n *node) when(cmd config.Command) (bool, error) {
+	if cmd.When == "" {
+		return true, nil
+	}
+
+	env := n.invocation(cmd).env
+	return config.EvalWhen(cmd.When, config.CurrentWhenContext(func(key string) string {
+		if v, ok := env[envKey(key)]; ok {
+			return v
+		}
+
+		return os.Getenv(key)
+	}))
+}
+
+// invocation is everything needed to start a single command
+type invocation struct {
+	shell string
+	args  []string
+	dir   string
+	env   map[string]string
+}
+
+func (n *node) invocation(cmd config.Command) invocation {
+	inv := invocation{dir: n.workDir(), env: n.env}
+
+	if cmd.Dir != "" {
+		inv.dir = resolvePath(inv.dir, cmd.Dir)
+	}
+
+	if len(cmd.Env) > 0 {
+		inv.env = maps.Clone(n.env)
+		if inv.env == nil {
+			inv.env = make(map[string]string)
+		}
+
+		for k, v := range cmd.Env {
+			inv.env[envKey(k)] = v
+		}
+	}
+
+	// a command specific shell is started on its own, without the arguments
+	// of the environment, which often set up a whole developer shell
+	if cmd.Shell != "" {
+		inv.shell = cmd.Shell
+		inv.args = append(config.ShellArgs(cmd.Shell), cmd.Run)
+		return inv
+	}
+
+	env := n.cfg.Env[runtime.GOOS]
+	inv.shell = env.Path
+	inv.args = shellArgs(env.Path, env.Args, cmd.Run)
+	return inv
+}
+
+// workDir is the directory commands of the target run in, output_dir, inputs
+// and outputs stay relative to the project itself
+func (n *node) workDir() string {
+	return resolvePath(n.dir, n.target.Dir)
+}
+
+func resolvePath(base, p string) string {
+	if p == "" {
+		return base
+	}
+
+	if filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(base, p)
+}
+
+// shellArgs splices a command into the argument list of the configured shell
+func shellArgs(shell string, envArgs []string, cmd string) []string {
+	args := make([]string, len(envArgs))
+	copy(args, envArgs)
+
+	switch shell {
+	case "cmd.exe":
+		args = append(args, "&&", cmd)
+	default:
+		args = append(args, cmd)
+	}
+
+	return args
+}
@@ -4,156 +4,239 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"runtime"
-	"slices"
 	"strings"
 
+	"github.com/kociumba/krill/cache"
 	"github.com/kociumba/krill/cli_utils"
 	"github.com/kociumba/krill/config"
+	"github.com/kociumba/krill/telemetry"
+	"github.com/kociumba/krill/templating"
 	"github.com/urfave/cli/v3"
 )
 
-func GenerateBuildCmds(cfg config.Cfg) []*cli.Command {
+var RunFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:    "jobs",
+		Aliases: []string{"j"},
+		Value:   runtime.NumCPU(),
+		Usage:   "Maximum number of targets to build concurrently",
+	},
+	&cli.BoolFlag{
+		Name:    "watch",
+		Aliases: []string{"w"},
+		Usage:   "Keep running and rebuild the target every time its inputs or the project files change",
+	},
+	&cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Print the resolved build order, commands and shell invocations without running anything",
+	},
+	&cli.BoolFlag{
+		Name:  "force",
+		Usage: "Run every target, even the ones whose inputs did not change since the last run",
+	},
+	&cli.BoolFlag{
+		Name:    "keep-going",
+		Aliases: []string{"k"},
+		Usage:   "Keep building everything that does not depend on a failed target, and report all failures at the end",
+	},
+	&cli.DurationFlag{
+		Name:    "grace-period",
+		Value:   defaultGracePeriod,
+		Usage:   "How long interrupted commands get to exit after the signal is forwarded to them, before they are killed",
+		Sources: cli.EnvVars("KRILL_GRACE_PERIOD"),
+	},
+	&cli.StringSliceFlag{
+		Name:  "tag",
+		Usage: "Also run every target with this tag, can be repeated",
+	},
+	&cli.StringSliceFlag{
+		Name:  "exclude-tag",
+		Usage: "Leave out targets with this tag, unless another selected target depends on them, can be repeated",
+	},
+	&cli.StringFlag{
+		Name:  "trace",
+		Usage: "Write the timing of every target and command to this file, in the Chrome trace event format (open in Perfetto or chrome://tracing)",
+	},
+}
+
+func GenerateBuildCmds(cfg config.Cfg) ([]*cli.Command, error) {
+	if err := validateTargets(&cfg); err != nil {
+		return nil, err
+	}
+
 	var subcommands []*cli.Command
-	for targetName := range cfg.BuildTargets {
+	for _, targetName := range sortedKeys(cfg.BuildTargets) {
+		target := cfg.BuildTargets[targetName]
+		usage := target.Description
+		if usage == "" {
+			usage = fmt.Sprintf("Run build commands for target %s", targetName)
+		}
+
 		subcommands = append(subcommands, &cli.Command{
-			Name:  targetName,
-			Usage: fmt.Sprintf("Run build commands for target %s", targetName),
+			Name:      targetName,
+			Aliases:   target.Aliases,
+			Usage:     usage,
+			ArgsUsage: "[targets...] [-- args]",
+			Hidden:    target.Hidden || !target.Supported(),
+			Flags:     paramFlags(target.Params),
 			Action: func(ctx context.Context, cmd *cli.Command) error {
-				visited := make(map[string]struct{})
-				return buildTarget(ctx, &cfg, targetName, visited)
+				return RunTargets(ctx, cmd, cfg, targetName)
 			},
 		})
 	}
 
-	return subcommands
+	return subcommands, nil
 }
 
-func buildTarget(ctx context.Context, cfg *config.Cfg, targetName string, visited map[string]struct{}) error {
-	target, ok := cfg.BuildTargets[targetName]
-	if !ok {
-		return fmt.Errorf("Target %s does not exist in the project", targetName)
+// RunTargets runs the targets selected on the command line of krill run, first
+// is the target whose subcommand was run, empty if krill run itself was run.
+// Positional arguments select more targets, arguments after "--" are passed
+// to the templates as args.
+func RunTargets(ctx context.Context, cmd *cli.Command, cfg config.Cfg, first string) error {
+	patterns, args := splitArgs(cmd)
+	if first != "" {
+		patterns = append([]string{first}, patterns...)
 	}
 
-	wd, err := os.Getwd()
-	if err != nil {
-		return err
+	sel := Selection{
+		Patterns:    patterns,
+		Tags:        cmd.StringSlice("tag"),
+		ExcludeTags: cmd.StringSlice("exclude-tag"),
 	}
 
-	had_to_detect_env := false
+	if sel.empty() {
+		target := DefaultTarget(&cfg)
+		if target == "" {
+			return ListTargets(&cfg, TargetsOptions{})
+		}
 
-	if _, seen := visited[wd+"-"+targetName]; seen {
-		return fmt.Errorf("cycle detected at %s for target %s", wd, targetName)
+		sel.Patterns = []string{target}
 	}
 
-	visited[wd+"-"+targetName] = struct{}{}
+	targets, err := selectTargets(&cfg, sel)
+	if err != nil {
+		return err
+	}
 
-	for _, dep := range target.DependsOn {
-		if err := buildTarget(ctx, cfg, dep, visited); err != nil {
-			return fmt.Errorf("dependency %s failed: %w", dep, err)
+	for _, name := range targets {
+		if target, ok := cfg.BuildTargets[name]; ok {
+			if err := validateParams(name, target.Params); err != nil {
+				return err
+			}
 		}
 	}
 
-	isAggregate := len(target.DependsOn) > 0 && len(target.Commands) == 0 && target.OutputDir == ""
-
-	isToolSpecific := false
-	parts := strings.Split(targetName, "-")
-	if len(parts) >= 2 {
-		base := parts[0]
-		suffix := strings.Join(parts[1:], "-")
-		if base == "debug" || base == "release" {
-			for _, tool := range cfg.Project.Tools {
-				if strings.ToLower(tool.String()) == suffix {
-					isToolSpecific = true
-					break
-				}
-			}
+	opts := Options{
+		Jobs:      int(cmd.Int("jobs")),
+		Force:     cmd.Bool("force"),
+		DryRun:    cmd.Bool("dry-run"),
+		KeepGoing: cmd.Bool("keep-going"),
+		Trace:     cmd.String("trace"),
+		Grace:     cmd.Duration("grace-period"),
+	}
+
+	// the config is expanded again with the values given on the command line,
+	// only the target of the subcommand has its params as flags
+	params := cfg.BuildTargets[first].Params
+	runCfg := &cfg
+	if len(params) > 0 || len(args) > 0 {
+		expanded, err := templating.ExpandConfigWith(config.CFG_unexpanded, joinArgs(config.CFG_unexpanded.Env[runtime.GOOS].Path, args), first, paramValues(cmd, params))
+		if err != nil {
+			return fmt.Errorf("could not expand templating arguments in config: %w", err)
 		}
+
+		runCfg = &expanded
 	}
 
-	if isAggregate && !isToolSpecific {
-		for subPath, subNested := range cfg.Nested {
-			subDir := filepath.Join(wd, subPath)
-			subCfg, err := config.GetConfigFromDir(subDir)
+	if cmd.Bool("watch") {
+		// the targets and the flags of the command line stay the same, only
+		// the config itself is read again
+		load := func() (*config.Cfg, error) {
+			raw, err := config.GetConfig()
 			if err != nil {
-				return fmt.Errorf("failed to load nested config at %s: %w", subPath, err)
+				return nil, err
 			}
 
-			subTarget := targetName
-			if mapping, ok := subNested.Mappings[targetName]; ok {
-				subTarget = mapping
-			}
-
-			if err := os.Chdir(subDir); err != nil {
-				return err
+			expanded, err := templating.ExpandConfigWith(raw, joinArgs(raw.Env[runtime.GOOS].Path, args), first, paramValues(cmd, params))
+			if err != nil {
+				return nil, fmt.Errorf("could not expand templating arguments in config: %w", err)
 			}
 
-			defer os.Chdir(wd)
-			if err := buildTarget(ctx, &subCfg, subTarget, visited); err != nil {
-				return fmt.Errorf("failed building nested %s: %w", subPath, err)
-			}
+			return &expanded, nil
 		}
+
+		return watchTarget(ctx, runCfg, load, targets, opts)
 	}
 
-	if target.OutputDir != "" {
-		outputPath := filepath.Join(wd, target.OutputDir)
-		if err := os.MkdirAll(outputPath, 0755); err != nil {
-			return fmt.Errorf("failed to create output directory %q: %w", target.OutputDir, err)
-		}
+	return buildTarget(ctx, runCfg, targets, opts)
+}
 
-		gitignorePath := filepath.Join(outputPath, ".gitignore")
-		if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
-			if err := os.WriteFile(gitignorePath, []byte("*"), 0644); err != nil {
-				return fmt.Errorf("failed to write .gitignore in %q: %w", target.OutputDir, err)
-			}
-		}
+func buildTarget(ctx context.Context, cfg *config.Cfg, targets []string, opts Options) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
 	}
 
-	for _, cmd := range target.Commands {
-		fmt.Println("Running:", cmd)
+	p, err := newPlan(cfg, wd, targets)
+	if err != nil {
+		return err
+	}
 
-		if cfg.Env[runtime.GOOS].Path == "" {
-			env, err := config.DetectEnvironment(
-				slices.Contains(cfg.Project.Languages, config.C) ||
-					slices.Contains(cfg.Project.Languages, config.Cpp))
-			if err != nil {
-				return err
-			}
+	if opts.DryRun {
+		return p.dryRun()
+	}
 
-			had_to_detect_env = true
-			cfg.Env[runtime.GOOS] = *env
-		}
+	if opts.Grace > 0 {
+		p.gracePeriod = opts.Grace
+	}
 
-		args := make([]string, len(cfg.Env[runtime.GOOS].Args))
-		copy(args, cfg.Env[runtime.GOOS].Args)
+	p.cache, err = cache.FromConfig(cfg.Cache)
+	if err != nil {
+		cli_utils.PrintWarningMessage(fmt.Sprintf("build cache disabled: %v", err))
+	}
 
-		switch cfg.Env[runtime.GOOS].Path {
-		case "powershell.exe":
-			if len(args) > 0 && args[len(args)-1] == "-Command" {
-				args = append(args, cmd)
-			} else {
-				args = append(args, cmd)
-			}
-		case "cmd.exe":
-			args = append(args, "&&", cmd)
-		default:
-			args = append(args, cmd)
+	p.logs, err = newRunLog(wd, strings.Join(targets, " "))
+	if err != nil {
+		cli_utils.PrintWarningMessage(fmt.Sprintf("logs for this run will not be saved: %v", err))
+	}
+
+	err = p.execute(ctx, opts)
+	p.logs.finish(err)
+	printTimings(&p.logs.record)
+
+	if opts.Trace != "" {
+		if terr := writeTrace(opts.Trace, &p.logs.record); terr != nil {
+			cli_utils.PrintWarningMessage(fmt.Sprintf("failed to write trace: %v", terr))
+		} else {
+			cli_utils.PrintInfoMessage(fmt.Sprintf("Trace written to %s", opts.Trace))
 		}
+	}
+
+	if exp, ok, oerr := telemetry.FromEnv(); oerr != nil {
+		cli_utils.PrintWarningMessage(fmt.Sprintf("OpenTelemetry export disabled: %v", oerr))
+	} else if ok {
+		// the run context may already be cancelled, the export should still go out
+		if oerr := exportSpans(context.WithoutCancel(ctx), exp, wd, &p.logs.record, err); oerr != nil {
+			cli_utils.PrintWarningMessage(fmt.Sprintf("failed to export spans: %v", oerr))
+		}
+	}
 
-		run := exec.CommandContext(ctx, cfg.Env[runtime.GOOS].Path, args...)
-		run.Dir = wd
-		run.Stderr = os.Stderr
-		run.Stdout = os.Stdout
-		run.Stdin = os.Stdin
+	if p.logs.dir != "" {
+		if perr := pruneLogs(wd, cfg.Logs.Keep); perr != nil {
+			cli_utils.PrintWarningMessage(fmt.Sprintf("failed to remove old logs: %v", perr))
+		}
 
-		if err := run.Run(); err != nil {
-			return fmt.Errorf("command %q failed: %w", cmd, err)
+		if err != nil {
+			cli_utils.PrintInfoMessage(fmt.Sprintf("Logs of this run: krill logs --run %s", p.logs.record.ID))
 		}
 	}
 
-	if had_to_detect_env {
+	if err != nil {
+		return err
+	}
+
+	if p.detectedEnv[wd] {
 		ok, err := cli_utils.Prompt(fmt.Sprintf(
 			"krill had to detect a default env during this compilation, because '[env.%s]' is not defined in the current config.\nDo you want to save the detected env to the config?",
 			runtime.GOOS,
@@ -163,7 +246,13 @@ func buildTarget(ctx context.Context, cfg *config.Cfg, targetName string, visite
 		}
 
 		if ok {
-			config.CFG_unexpanded.Env[runtime.GOOS] = cfg.Env[runtime.GOOS]
+			if config.CFG_unexpanded.Env == nil {
+				config.CFG_unexpanded.Env = make(map[string]config.Environment)
+			}
+
+			env := config.CFG_unexpanded.Env[runtime.GOOS]
+			env.Path, env.Args = cfg.Env[runtime.GOOS].Path, cfg.Env[runtime.GOOS].Args
+			config.CFG_unexpanded.Env[runtime.GOOS] = env
 			if err := config.SaveConfig(config.CFG_unexpanded); err != nil {
 				return err
 			}
@@ -179,77 +268,77 @@ func DefaultTargetsForTool(tool config.Tool, artefact_name string, binType confi
 	switch tool {
 	case config.CMake:
 		targets["debug"] = config.BuildTarget{
-			Commands: []string{
+			Commands: config.Commands(
 				"cmake -S . -B {{ .targets.debug.output_dir }} -DCMAKE_BUILD_TYPE=Debug",
 				"cmake --build {{ .targets.debug.output_dir }}",
-			},
+			),
 			OutputDir: "cmake-build-debug",
 		}
 		targets["release"] = config.BuildTarget{
-			Commands: []string{
+			Commands: config.Commands(
 				"cmake -S . -B {{ .targets.release.output_dir }} -DCMAKE_BUILD_TYPE=Release",
 				"cmake --build {{ .targets.release.output_dir }}",
-			},
+			),
 			OutputDir: "cmake-build-release",
 		}
 	case config.Gradle:
 		targets["debug"] = config.BuildTarget{
-			Commands:  []string{"./gradlew build -PbuildType=debug"},
+			Commands:  config.Commands("./gradlew build -PbuildType=debug"),
 			OutputDir: "build",
 		}
 		targets["release"] = config.BuildTarget{
-			Commands:  []string{"./gradlew build -PbuildType=release"},
+			Commands:  config.Commands("./gradlew build -PbuildType=release"),
 			OutputDir: "build",
 		}
 	case config.Meson:
 		targets["debug"] = config.BuildTarget{
-			Commands: []string{
+			Commands: config.Commands(
 				"meson setup {{ .targets.debug.output_dir }} --buildtype=debug",
 				"meson compile -C {{ .targets.debug.output_dir }}",
-			},
+			),
 			OutputDir: "meson-build-debug",
 		}
 		targets["release"] = config.BuildTarget{
-			Commands: []string{
+			Commands: config.Commands(
 				"meson setup {{ .targets.release.output_dir }} --buildtype=release",
 				"meson compile -C {{ .targets.release.output_dir }}",
-			},
+			),
 			OutputDir: "meson-build-release",
 		}
 	case config.Cargo:
 		targets["debug"] = config.BuildTarget{
-			Commands:  []string{"cargo build"},
+			Commands:  config.Commands("cargo build"),
 			OutputDir: "target/debug",
 		}
 		targets["release"] = config.BuildTarget{
-			Commands:  []string{"cargo build --release"},
+			Commands:  config.Commands("cargo build --release"),
 			OutputDir: "target/release",
 		}
 	case config.GoCmd:
 		targets["debug"] = config.BuildTarget{
-			Commands:  []string{"go build -gcflags=\"-N -l\" -o {{ .targets.debug.output_dir }}/{{ .project.name }}{{ .exe_ext }}"},
+			Commands:  config.Commands("go build -gcflags=\"-N -l\" -o {{ .targets.debug.output_dir }}/{{ .project.name }}{{ .exe_ext }}"),
 			OutputDir: "bin/debug",
 		}
 		targets["release"] = config.BuildTarget{
-			Commands:  []string{"go build -ldflags=\"-s -w\" -o {{ .targets.release.output_dir }}/{{ .project.name }}{{ .exe_ext }}"},
+			Commands:  config.Commands("go build -ldflags=\"-s -w\" -o {{ .targets.release.output_dir }}/{{ .project.name }}{{ .exe_ext }}"),
 			OutputDir: "bin/release",
 		}
 	case config.OdinCmd:
 		targets["debug"] = config.BuildTarget{
-			Commands:  []string{"odin build . -debug -out:{{ .targets.debug.output_dir }}/{{ .project.name }}{{ .exe_ext }}"},
+			Commands:  config.Commands("odin build . -debug -out:{{ .targets.debug.output_dir }}/{{ .project.name }}{{ .exe_ext }}"),
 			OutputDir: "bin/debug",
 		}
 		targets["release"] = config.BuildTarget{
-			Commands:  []string{"odin build . -o:speed -out:{{ .targets.release.output_dir }}/{{ .project.name }}{{ .exe_ext }}"},
+			Commands:  config.Commands("odin build . -o:speed -out:{{ .targets.release.output_dir }}/{{ .project.name }}{{ .exe_ext }}"),
 			OutputDir: "bin/release",
 		}
 	case config.DotNet:
 		targets["debug"] = config.BuildTarget{
-			Commands:  []string{"dotnet build -c Debug"},
+			Commands:  config.Commands("dotnet build -c Debug"),
 			OutputDir: "bin/Debug",
 		}
 		targets["release"] = config.BuildTarget{
-			Commands:  []string{"dotnet build -c Release"},
+			Commands:  config.Commands("dotnet build -c Release"),
 			OutputDir: "bin/Release",
 		}
 	case config.Nob:
@@ -258,23 +347,23 @@ func DefaultTargetsForTool(tool config.Tool, artefact_name string, binType confi
 			nobBinary = "nob.exe"
 		}
 		targets["default"] = config.BuildTarget{
-			Commands: []string{nobBinary},
+			Commands: config.Commands(nobBinary),
 		}
 
 	// these defaults might get removed like the raw compiler targets
 	case config.Make:
 		targets["debug"] = config.BuildTarget{
-			Commands: []string{"make debug"},
+			Commands: config.Commands("make debug"),
 		}
 		targets["release"] = config.BuildTarget{
-			Commands: []string{"make release"},
+			Commands: config.Commands("make release"),
 		}
 	case config.Taskfile:
 		targets["debug"] = config.BuildTarget{
-			Commands: []string{"task build:debug"},
+			Commands: config.Commands("task build:debug"),
 		}
 		targets["release"] = config.BuildTarget{
-			Commands: []string{"task build:release"},
+			Commands: config.Commands("task build:release"),
 		}
 	}
 
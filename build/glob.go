@@ -0,0 +1,148 @@
+package build
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kociumba/krill/config"
+)
+
+var globSkipDirs = map[string]struct{}{
+	".git":   {},
+	".krill": {},
+}
+
+// expandGlobs resolves a list of glob patterns relative to root, on top of
+// the usual filepath.Match syntax a "**" path segment matches any number of
+// directories. Returned paths are slash separated, relative to root, sorted
+// and without duplicates.
+//
+// Every pattern is only walked from its literal prefix, ".git", ".krill" and
+// nested projects below it are left out, patterns have to stay inside root.
+func expandGlobs(root string, patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	var out []string
+	for _, pattern := range patterns {
+		clean := path.Clean(filepath.ToSlash(pattern))
+		if _, err := path.Match(clean, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", pattern, err)
+		}
+
+		if !filepath.IsLocal(filepath.FromSlash(clean)) {
+			return nil, fmt.Errorf("invalid glob %q: patterns have to be relative and can't leave %s", pattern, root)
+		}
+
+		split := strings.Split(clean, "/")
+		prefix := 0
+		for prefix < len(split) && !hasGlobMeta(split[prefix]) {
+			prefix++
+		}
+
+		if prefix == len(split) {
+			// a plain path, nothing to walk
+			if fi, err := os.Stat(filepath.Join(root, filepath.FromSlash(clean))); err == nil && !fi.IsDir() {
+				out = append(out, clean)
+			}
+
+			continue
+		}
+
+		start := filepath.Join(root, filepath.FromSlash(path.Join(split[:prefix]...)))
+		if fi, err := os.Stat(start); err != nil || !fi.IsDir() {
+			continue
+		}
+
+		err := filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if d.IsDir() {
+				if p == start {
+					return nil
+				}
+
+				if _, skip := globSkipDirs[d.Name()]; skip {
+					return filepath.SkipDir
+				}
+
+				if _, err := os.Stat(config.ConfigPath(p)); err == nil {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+
+			rel = filepath.ToSlash(rel)
+			if matchGlob(split, strings.Split(rel, "/")) {
+				out = append(out, rel)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	slices.Sort(out)
+	return slices.Compact(out), nil
+}
+
+// hasGlobMeta reports whether a path segment has to be matched instead of
+// compared literally
+func hasGlobMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+func matchGlob(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlob(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// matchesAny reports whether a slash separated relative path matches any of
+// the glob patterns
+func matchesAny(patterns []string, rel string) bool {
+	parts := strings.Split(rel, "/")
+	for _, pattern := range patterns {
+		if matchGlob(strings.Split(path.Clean(filepath.ToSlash(pattern)), "/"), parts) {
+			return true
+		}
+	}
+
+	return false
+}
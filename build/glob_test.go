@@ -0,0 +1,108 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"main.go", "main.go", true},
+		{"main.go", "src/main.go", false},
+		{"*.go", "main.go", true},
+		{"*.go", "src/main.go", false},
+		{"src/*.go", "src/main.go", true},
+		{"src/?.go", "src/a.go", true},
+		{"src/[ab].go", "src/c.go", false},
+
+		// ** matches any number of directories, including none
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "a/b/c/main.go", true},
+		{"**/*.go", "a/b/c/main.c", false},
+		{"src/**", "src/a/b.go", true},
+		{"src/**", "src", true},
+		{"src/**", "lib/a.go", false},
+		{"src/**/test/*.go", "src/test/a.go", true},
+		{"src/**/test/*.go", "src/a/b/test/a.go", true},
+		{"src/**/test/*.go", "src/a/b/a.go", false},
+		{"**/gen/**/*.pb.go", "api/gen/v1/a.pb.go", true},
+		{"**", "anything/at/all", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			got := matchGlob(strings.Split(tt.pattern, "/"), strings.Split(tt.name, "/"))
+			if got != tt.want {
+				t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandGlobs(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{
+		"main.go",
+		"go.mod",
+		"src/a.go",
+		"src/nested/b.go",
+		"src/nested/b_test.go",
+		".git/config.go",
+		".krill/state.go",
+		"src/.krill/c.go",
+		"lib/krill.toml",
+		"lib/c.go",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{"no patterns", nil, nil},
+		{"top level only", []string{"*.go"}, []string{"main.go"}},
+		{"skips .git, .krill and nested projects", []string{"**/*.go"}, []string{"main.go", "src/a.go", "src/nested/b.go", "src/nested/b_test.go"}},
+		{"overlapping patterns", []string{"src/**", "src/nested/*.go", "go.mod"}, []string{"go.mod", "src/a.go", "src/nested/b.go", "src/nested/b_test.go"}},
+		{"unclean pattern", []string{"./src/../src/*.go"}, []string{"src/a.go"}},
+		{"no match", []string{"*.c"}, nil},
+		{"missing prefix", []string{"gen/**"}, nil},
+		{"inside a nested project", []string{"lib/*.go"}, []string{"lib/c.go"}},
+		{"plain path", []string{"src/nested/b.go"}, []string{"src/nested/b.go"}},
+		{"plain directory", []string{"src"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandGlobs(root, tt.patterns)
+			if err != nil {
+				t.Fatalf("expandGlobs() error = %v", err)
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("expandGlobs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, pattern := range []string{"src/[.go", "../*.go", "src/../../*.go", filepath.Join(root, "*.go")} {
+		if _, err := expandGlobs(root, []string{pattern}); err == nil {
+			t.Errorf("expandGlobs() accepted %q", pattern)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kociumba/krill/config"
+)
+
+type GraphOptions struct {
+	Format  string // dot, mermaid or json
+	Target  string // only the target and its dependencies
+	Reverse string // only the target and everything depending on it
+	Path    []string
+}
+
+type graphNode struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Project   string   `json:"project"`
+	Commands  int      `json:"commands"`
+	OutputDir string   `json:"output_dir,omitempty"`
+	DependsOn []string `json:"depends_on"`
+}
+
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// WriteGraph writes the dependency graph of every target in the project and
+// its nested projects, edges point from a target to its dependencies.
+// Targets in nested projects are named "<path>:<target>".
+func WriteGraph(w io.Writer, cfg *config.Cfg, opts GraphOptions) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	p := newGraph(cfg, wd)
+	p.lenient = true
+	if err := p.addAll(cfg, wd); err != nil {
+		return err
+	}
+
+	nodes := p.order
+	switch {
+	case len(opts.Path) > 0:
+		if len(opts.Path) != 2 {
+			return fmt.Errorf("--path needs exactly two targets, got %d", len(opts.Path))
+		}
+
+		from, err := p.find(opts.Path[0])
+		if err != nil {
+			return err
+		}
+
+		to, err := p.find(opts.Path[1])
+		if err != nil {
+			return err
+		}
+
+		down := reachable(from, func(n *node) []*node { return n.deps })
+		up := reachable(to, func(n *node) []*node { return n.dependents })
+		nodes = filterNodes(p.order, func(n *node) bool { return down[n] && up[n] })
+		if len(nodes) == 0 {
+			return fmt.Errorf("%s does not depend on %s", opts.Path[0], opts.Path[1])
+		}
+	case opts.Reverse != "":
+		n, err := p.find(opts.Reverse)
+		if err != nil {
+			return err
+		}
+
+		up := reachable(n, func(n *node) []*node { return n.dependents })
+		nodes = filterNodes(p.order, func(n *node) bool { return up[n] })
+	case opts.Target != "":
+		n, err := p.find(opts.Target)
+		if err != nil {
+			return err
+		}
+
+		down := reachable(n, func(n *node) []*node { return n.deps })
+		nodes = filterNodes(p.order, func(n *node) bool { return down[n] })
+	}
+
+	included := make(map[*node]bool, len(nodes))
+	for _, n := range nodes {
+		included[n] = true
+	}
+
+	var gnodes []graphNode
+	var edges []graphEdge
+	for _, n := range nodes {
+		gn := graphNode{
+			ID:        p.label(n),
+			Name:      n.name,
+			Project:   filepath.ToSlash(p.relDir(n)),
+			Commands:  len(n.target.Commands) + len(n.target.Pre) + len(n.target.Post),
+			OutputDir: n.target.OutputDir,
+			DependsOn: []string{},
+		}
+
+		for _, d := range n.deps {
+			if !included[d] {
+				continue
+			}
+
+			gn.DependsOn = append(gn.DependsOn, p.label(d))
+			edges = append(edges, graphEdge{From: p.label(n), To: p.label(d)})
+		}
+
+		gnodes = append(gnodes, gn)
+	}
+
+	switch opts.Format {
+	case "", "dot":
+		return writeDot(w, gnodes, edges)
+	case "mermaid":
+		return writeMermaid(w, gnodes, edges)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			Nodes []graphNode `json:"nodes"`
+			Edges []graphEdge `json:"edges"`
+		}{gnodes, edges})
+	default:
+		return fmt.Errorf("unknown graph format %q, expected dot, mermaid or json", opts.Format)
+	}
+}
+
+// find resolves a target by the name used in the graph output, targets of
+// the root project can also be found by their aliases
+func (p *plan) find(label string) (*node, error) {
+	label = strings.TrimPrefix(label, "//")
+	for _, n := range p.order {
+		if p.label(n) == label {
+			return n, nil
+		}
+	}
+
+	if name, ok := resolveTarget(p.configs[p.dir], label); ok {
+		for _, n := range p.order {
+			if n.dir == p.dir && n.name == name {
+				return n, nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("Target %s does not exist in the project", label)
+}
+
+func reachable(start *node, next func(*node) []*node) map[*node]bool {
+	seen := map[*node]bool{start: true}
+	stack := []*node{start}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, m := range next(n) {
+			if !seen[m] {
+				seen[m] = true
+				stack = append(stack, m)
+			}
+		}
+	}
+
+	return seen
+}
+
+func filterNodes(nodes []*node, keep func(*node) bool) []*node {
+	var out []*node
+	for _, n := range nodes {
+		if keep(n) {
+			out = append(out, n)
+		}
+	}
+
+	return out
+}
+
+func writeDot(w io.Writer, nodes []graphNode, edges []graphEdge) error {
+	var sb strings.Builder
+	sb.WriteString("digraph krill {\n")
+	sb.WriteString("  rankdir=LR;\n")
+	sb.WriteString("  node [shape=box];\n")
+
+	for _, n := range nodes {
+		attrs := ""
+		if n.Commands == 0 {
+			attrs = ", style=dashed"
+		}
+
+		fmt.Fprintf(&sb, "  %s [label=%s%s];\n", strconv.Quote(n.ID), strconv.Quote(n.ID), attrs)
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(&sb, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+	}
+
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func writeMermaid(w io.Writer, nodes []graphNode, edges []graphEdge) error {
+	ids := make(map[string]string, len(nodes))
+	var sb strings.Builder
+	sb.WriteString("graph LR\n")
+
+	for i, n := range nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+		left, right := "[", "]"
+		if n.Commands == 0 {
+			left, right = "([", "])"
+		}
+
+		fmt.Fprintf(&sb, "  %s%s\"%s\"%s\n", ids[n.ID], left, strings.ReplaceAll(n.ID, `"`, "#quot;"), right)
+	}
+
+	for _, e := range edges {
+		fmt.Fprintf(&sb, "  %s --> %s\n", ids[e.From], ids[e.To])
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
@@ -0,0 +1,300 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+const stateDir = ".krill"
+const stateFile = "state.json"
+
+// fingerprintVersion is hashed into every fingerprint, changing what is hashed
+// has to change it, so old states and cache entries are never matched
+const fingerprintVersion = 2
+
+type targetState struct {
+	Fingerprint string `json:"fingerprint"`
+}
+
+// projectState is the on disk record of the last successful run of every
+// target in a single project, stored in .krill/state.json
+type projectState struct {
+	Targets map[string]targetState `json:"targets"`
+
+	dirty bool
+}
+
+type stateStore struct {
+	mu       sync.Mutex
+	projects map[string]*projectState
+}
+
+func newStateStore() *stateStore {
+	return &stateStore{projects: make(map[string]*projectState)}
+}
+
+func (s *stateStore) project(dir string) *projectState {
+	if ps, ok := s.projects[dir]; ok {
+		return ps
+	}
+
+	ps := &projectState{Targets: make(map[string]targetState)}
+	if b, err := os.ReadFile(filepath.Join(dir, stateDir, stateFile)); err == nil {
+		// a corrupted state file only means everything is rebuilt once
+		_ = json.Unmarshal(b, ps)
+		if ps.Targets == nil {
+			ps.Targets = make(map[string]targetState)
+		}
+	}
+
+	s.projects[dir] = ps
+	return ps
+}
+
+func (s *stateStore) get(dir, target string) (targetState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.project(dir).Targets[target]
+	return st, ok
+}
+
+func (s *stateStore) set(dir, target string, st targetState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps := s.project(dir)
+	ps.Targets[target] = st
+	ps.dirty = true
+}
+
+func (s *stateStore) forget(dir, target string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps := s.project(dir)
+	if _, ok := ps.Targets[target]; ok {
+		delete(ps.Targets, target)
+		ps.dirty = true
+	}
+}
+
+func (s *stateStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for dir, ps := range s.projects {
+		if !ps.dirty {
+			continue
+		}
+
+		if err := ensureStateDir(dir); err != nil {
+			return err
+		}
+
+		b, err := json.MarshalIndent(ps, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal build state: %w", err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, stateDir, stateFile), b, 0644); err != nil {
+			return fmt.Errorf("failed to write build state: %w", err)
+		}
+
+		ps.dirty = false
+	}
+
+	return nil
+}
+
+func ensureStateDir(dir string) error {
+	path := filepath.Join(dir, stateDir)
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create %s directory: %w", stateDir, err)
+	}
+
+	gitignorePath := filepath.Join(path, ".gitignore")
+	if _, err := os.Stat(gitignorePath); os.IsNotExist(err) {
+		if err := os.WriteFile(gitignorePath, []byte("*"), 0644); err != nil {
+			return fmt.Errorf("failed to write .gitignore in %q: %w", stateDir, err)
+		}
+	}
+
+	return nil
+}
+
+// computeFingerprint hashes everything that influences the result of a target: the
+// content of its inputs, the expanded commands, the shell, the declared
+// environment and the fingerprints of its dependencies. Targets without
+// declared inputs have no fingerprint and always run, and so do the targets
+// depending on them.
+func (n *node) computeFingerprint() (string, error) {
+	if len(n.target.Inputs) == 0 {
+		return "", nil
+	}
+
+	// whatever a dependency without a fingerprint produced may have changed
+	if slices.ContainsFunc(n.deps, func(d *node) bool { return d.untracked }) {
+		return "", nil
+	}
+
+	h := sha256.New()
+	env := n.cfg.Env[runtime.GOOS]
+
+	// fingerprints are also keys of the shared cache, outputs of one
+	// platform must never be restored on another
+	fmt.Fprintf(h, "version\x00%d\x00", fingerprintVersion)
+	fmt.Fprintf(h, "platform\x00%s\x00%s\x00", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(h, "shell\x00%s\x00", env.Path)
+	for _, arg := range env.Args {
+		fmt.Fprintf(h, "arg\x00%s\x00", arg)
+	}
+
+	// the declared variables are hashed instead of the resolved ones, which
+	// hold the PATH of the host and absolute paths of the checkout
+	layers := []struct {
+		name    string
+		files   []string
+		env     map[string]string
+		prepend []string
+	}{
+		{"environment", env.EnvFiles, env.Env, env.PathPrepend},
+		{"target", n.target.EnvFiles, n.target.Env, n.target.PathPrepend},
+	}
+
+	for _, layer := range layers {
+		for _, file := range layer.files {
+			sum, err := hashFile(filepath.Join(n.dir, file))
+			if os.IsNotExist(err) {
+				sum = "missing"
+			} else if err != nil {
+				return "", err
+			}
+
+			fmt.Fprintf(h, "env_file\x00%s\x00%s\x00%s\x00", layer.name, file, sum)
+		}
+
+		for _, k := range sortedKeys(layer.env) {
+			fmt.Fprintf(h, "env\x00%s\x00%s\x00%s\x00", layer.name, k, layer.env[k])
+		}
+
+		for _, p := range layer.prepend {
+			fmt.Fprintf(h, "path_prepend\x00%s\x00%s\x00", layer.name, p)
+		}
+	}
+
+	fmt.Fprintf(h, "dir\x00%s\x00", n.target.Dir)
+	for _, hook := range n.target.Hooks() {
+		for _, cmd := range hook.Commands {
+			b, err := cmd.MarshalTOML()
+			if err != nil {
+				return "", err
+			}
+
+			if hook.Stage == "" {
+				fmt.Fprintf(h, "cmd\x00%s\x00", b)
+			} else {
+				fmt.Fprintf(h, "hook\x00%s\x00%s\x00", hook.Stage, b)
+			}
+		}
+	}
+
+	fmt.Fprintf(h, "output_dir\x00%s\x00", n.target.OutputDir)
+	for _, out := range n.target.Outputs {
+		fmt.Fprintf(h, "output\x00%s\x00", out)
+	}
+
+	files, err := expandGlobs(n.dir, n.target.Inputs)
+	if err != nil {
+		return "", err
+	}
+
+	for _, file := range files {
+		sum, err := hashFile(filepath.Join(n.dir, file))
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "input\x00%s\x00%s\x00", file, sum)
+	}
+
+	// dependencies are identified relative to the target, so the same
+	// project checked out in different places produces the same keys
+	for _, dep := range n.deps {
+		rel, err := filepath.Rel(n.dir, dep.dir)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(h, "dep\x00%s\x00%s\x00%s\x00", filepath.ToSlash(rel), dep.name, dep.fingerprint)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// outputsExist reports whether every declared output pattern still matches
+// at least one file, a deleted output forces a rebuild even if the inputs
+// did not change
+func (n *node) outputsExist() (bool, error) {
+	for _, pattern := range n.target.Outputs {
+		files, err := expandGlobs(n.dir, []string{pattern})
+		if err != nil {
+			return false, err
+		}
+
+		if len(files) == 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+func (n *node) cacheable() bool {
+	if n.target.Cache != nil && !*n.target.Cache {
+		return false
+	}
+
+	// without fingerprints of all dependencies the key does not cover
+	// everything the outputs were built from
+	if slices.ContainsFunc(n.deps, func(d *node) bool { return d.fingerprint == "" }) {
+		return false
+	}
+
+	return len(n.target.Inputs) > 0 && (n.target.OutputDir != "" || len(n.target.Outputs) > 0)
+}
+
+// outputFiles lists everything a target produced: the whole content of its
+// output_dir and every file matched by its outputs
+func (n *node) outputFiles() ([]string, error) {
+	patterns := slices.Clone(n.target.Outputs)
+	if n.target.OutputDir != "" {
+		patterns = append(patterns, path.Join(filepath.ToSlash(n.target.OutputDir), "**"))
+	}
+
+	return expandGlobs(n.dir, patterns)
+}
@@ -0,0 +1,284 @@
+package build
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logsDir = "logs"
+const runRecordFile = "run.json"
+const defaultLogRetention = 20
+
+const (
+	StatusRunning  = "running"
+	StatusOK       = "ok"
+	StatusFailed   = "failed"
+	StatusUpToDate = "up to date"
+	StatusCached   = "cached"
+
+	StatusInterrupted = "interrupted"
+	StatusSkipped     = "skipped"
+)
+
+type RunRecord struct {
+	ID       string          `json:"id"`
+	Target   string          `json:"target"`
+	Status   string          `json:"status"`
+	Started  time.Time       `json:"started"`
+	Finished time.Time       `json:"finished,omitzero"`
+	Targets  []*TargetRecord `json:"targets"`
+}
+
+type TargetRecord struct {
+	Name      string           `json:"name"`
+	Dir       string           `json:"dir"`
+	DependsOn []string         `json:"depends_on,omitempty"`
+	Status    string           `json:"status"`
+	Started   time.Time        `json:"started"`
+	Finished  time.Time        `json:"finished,omitzero"`
+	Commands  []*CommandRecord `json:"commands,omitempty"`
+}
+
+type CommandRecord struct {
+	Command  string    `json:"command"`
+	Hook     string    `json:"hook,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished,omitzero"`
+	ExitCode int       `json:"exit_code"`
+	Error    string    `json:"error,omitempty"`
+	Failed   bool      `json:"failed,omitempty"` // failed the target, errors of ignore_error commands do not
+	Log      string    `json:"log"`
+}
+
+// runLog records every target and command of a single krill run into
+// .krill/logs/<run id>/, command output is teed into one file per command
+type runLog struct {
+	dir string
+
+	mu     sync.Mutex
+	record RunRecord
+}
+
+func newRunID() string {
+	b := make([]byte, 2)
+	rand.Read(b)
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(b)
+}
+
+// newRunLog always returns a usable run log, if the log directory can not be
+// created the run is still recorded in memory, for the timing report
+func newRunLog(projectDir, target string) (*runLog, error) {
+	l := &runLog{
+		record: RunRecord{
+			ID:      newRunID(),
+			Target:  target,
+			Status:  StatusRunning,
+			Started: time.Now(),
+		},
+	}
+
+	if err := ensureStateDir(projectDir); err != nil {
+		return l, err
+	}
+
+	dir := filepath.Join(projectDir, stateDir, logsDir, l.record.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return l, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	l.dir = dir
+	return l, l.save()
+}
+
+func (l *runLog) startTarget(name, dir string, deps []string) *TargetRecord {
+	t := &TargetRecord{Name: name, Dir: dir, DependsOn: deps, Status: StatusRunning, Started: time.Now()}
+
+	l.mu.Lock()
+	l.record.Targets = append(l.record.Targets, t)
+	l.mu.Unlock()
+
+	return t
+}
+
+func (l *runLog) finishTarget(t *TargetRecord, status string) {
+	l.mu.Lock()
+	t.Status = status
+	t.Finished = time.Now()
+	l.mu.Unlock()
+
+	l.save()
+}
+
+// startCommand opens the log file of the next command of a target
+func (l *runLog) startCommand(t *TargetRecord, cmd, hook string) (*CommandRecord, *os.File, error) {
+	l.mu.Lock()
+	c := &CommandRecord{
+		Command: cmd,
+		Hook:    hook,
+		Started: time.Now(),
+		Log:     filepath.ToSlash(filepath.Join(logFileName(t.Name), fmt.Sprintf("%02d.log", len(t.Commands)+1))),
+	}
+	t.Commands = append(t.Commands, c)
+	l.mu.Unlock()
+
+	if l.dir == "" {
+		return c, nil, nil
+	}
+
+	path := filepath.Join(l.dir, filepath.FromSlash(c.Log))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return c, f, nil
+}
+
+func (l *runLog) finishCommand(c *CommandRecord, err error) {
+	l.mu.Lock()
+	c.Finished = time.Now()
+	c.ExitCode = exitCode(err)
+	if err != nil {
+		c.Error = err.Error()
+	}
+	l.mu.Unlock()
+}
+
+// failCommand marks the command that failed its target
+func (l *runLog) failCommand(c *CommandRecord) {
+	l.mu.Lock()
+	c.Failed = true
+	l.mu.Unlock()
+}
+
+func (l *runLog) finish(err error) error {
+	l.mu.Lock()
+	l.record.Finished = time.Now()
+	l.record.Status = StatusOK
+	if errors.Is(err, ErrInterrupted) {
+		l.record.Status = StatusInterrupted
+	} else if err != nil {
+		l.record.Status = StatusFailed
+	}
+	l.mu.Unlock()
+
+	return l.save()
+}
+
+func (l *runLog) save() error {
+	if l.dir == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	b, err := json.MarshalIndent(l.record, "", "  ")
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(l.dir, runRecordFile), b, 0644)
+}
+
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+
+	return -1
+}
+
+func logFileName(label string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(label)
+}
+
+// syncWriter serializes writes of stdout and stderr into one log file
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// pruneLogs keeps only the newest keep runs, run ids sort chronologically
+func pruneLogs(projectDir string, keep int) error {
+	if keep <= 0 {
+		keep = defaultLogRetention
+	}
+
+	ids, err := runIDs(projectDir)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) <= keep {
+		return nil
+	}
+
+	for _, id := range ids[:len(ids)-keep] {
+		if err := os.RemoveAll(filepath.Join(projectDir, stateDir, logsDir, id)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func runIDs(projectDir string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(projectDir, stateDir, logsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func loadRun(projectDir, id string) (*RunRecord, error) {
+	b, err := os.ReadFile(filepath.Join(projectDir, stateDir, logsDir, id, runRecordFile))
+	if err != nil {
+		return nil, fmt.Errorf("could not read run %s: %w", id, err)
+	}
+
+	var r RunRecord
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("corrupted run record %s: %w", id, err)
+	}
+
+	return &r, nil
+}
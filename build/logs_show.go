@@ -0,0 +1,165 @@
+package build
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kociumba/krill/cli_utils"
+)
+
+type LogsOptions struct {
+	Run    string // run id, the latest run by default
+	Target string // print the output of this target
+	Failed bool   // print the output of every failed target
+	List   bool   // list the recorded runs
+}
+
+// ShowLogs prints the logs recorded by previous runs of the project in dir
+func ShowLogs(dir string, opts LogsOptions) error {
+	ids, err := runIDs(dir)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		cli_utils.PrintInfoMessage("No runs have been recorded in this project yet")
+		return nil
+	}
+
+	if opts.List {
+		return listRuns(dir, ids)
+	}
+
+	id := opts.Run
+	if id == "" {
+		id = ids[len(ids)-1]
+	}
+
+	run, err := loadRun(dir, id)
+	if err != nil {
+		return err
+	}
+
+	runDir := filepath.Join(dir, stateDir, logsDir, run.ID)
+	switch {
+	case opts.Target != "":
+		for _, t := range run.Targets {
+			if t.Name == opts.Target {
+				return printTargetLogs(runDir, t)
+			}
+		}
+
+		return fmt.Errorf("target %s did not run in %s", opts.Target, run.ID)
+	case opts.Failed:
+		found := false
+		for _, t := range run.Targets {
+			if t.Status == StatusFailed && len(t.Commands) > 0 {
+				found = true
+				if err := printTargetLogs(runDir, t); err != nil {
+					return err
+				}
+			}
+		}
+
+		if !found {
+			cli_utils.PrintNoIssuesFound("failed targets")
+		}
+
+		return nil
+	default:
+		printRunSummary(run)
+		return nil
+	}
+}
+
+func listRuns(dir string, ids []string) error {
+	var rows []cli_utils.TableRow
+	for i := len(ids) - 1; i >= 0; i-- {
+		run, err := loadRun(dir, ids[i])
+		if err != nil {
+			cli_utils.PrintWarningMessage(err.Error())
+			continue
+		}
+
+		rows = append(rows, cli_utils.TableRow{
+			Columns: []string{run.ID, run.Target, run.Status, run.Started.Format(time.DateTime), formatDuration(run.Started, run.Finished)},
+			Color:   statusColor(run.Status),
+		})
+	}
+
+	cli_utils.PrintTable([]string{"RUN", "TARGET", "STATUS", "STARTED", "DURATION"}, rows, []int{22, 20, 12, 19, 10})
+	return nil
+}
+
+func printRunSummary(run *RunRecord) {
+	cli_utils.PrintHeader(fmt.Sprintf("Run %s of %s: %s", run.ID, run.Target, run.Status), statusColor(run.Status))
+
+	var rows []cli_utils.TableRow
+	for _, t := range run.Targets {
+		rows = append(rows, cli_utils.TableRow{
+			Columns: []string{t.Name, t.Status, fmt.Sprint(len(t.Commands)), formatDuration(t.Started, t.Finished)},
+			Color:   statusColor(t.Status),
+		})
+	}
+
+	cli_utils.PrintTable([]string{"TARGET", "STATUS", "COMMANDS", "DURATION"}, rows, []int{30, 12, 8, 10})
+	fmt.Println()
+	cli_utils.PrintInfoMessage(fmt.Sprintf("Use 'krill logs --run %s <target>' to see the output of a target", run.ID))
+}
+
+func printTargetLogs(runDir string, t *TargetRecord) error {
+	cli_utils.PrintHeader(fmt.Sprintf("%s: %s", t.Name, t.Status), statusColor(t.Status))
+	if len(t.Commands) == 0 {
+		cli_utils.PrintColoredLine("(no commands were run)", cli_utils.ColorGray)
+		return nil
+	}
+
+	for _, c := range t.Commands {
+		if c.Hook != "" {
+			cli_utils.PrintColoredLine(fmt.Sprintf("$ %s (%s)", c.Command, c.Hook), cli_utils.ColorCyan)
+		} else {
+			cli_utils.PrintColoredLine(fmt.Sprintf("$ %s", c.Command), cli_utils.ColorCyan)
+		}
+
+		f, err := os.Open(filepath.Join(runDir, filepath.FromSlash(c.Log)))
+		if err != nil {
+			return fmt.Errorf("could not open log of %q: %w", c.Command, err)
+		}
+
+		_, err = io.Copy(os.Stdout, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+
+		if c.Error != "" {
+			cli_utils.PrintErrorMessage(fmt.Sprintf("exit code %d: %s", c.ExitCode, c.Error))
+		}
+	}
+
+	return nil
+}
+
+func statusColor(status string) string {
+	switch status {
+	case StatusOK:
+		return cli_utils.ColorGreen
+	case StatusFailed:
+		return cli_utils.ColorRed
+	case StatusRunning, StatusInterrupted:
+		return cli_utils.ColorYellow
+	default:
+		return cli_utils.ColorGray
+	}
+}
+
+func formatDuration(start, end time.Time) string {
+	if end.IsZero() {
+		return "-"
+	}
+
+	return end.Sub(start).Round(time.Millisecond).String()
+}
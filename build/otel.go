@@ -0,0 +1,134 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kociumba/krill/telemetry"
+)
+
+// exportSpans sends a finished run as a single trace. Targets of nested
+// projects are grouped under a span of their project, dependencies between
+// targets are recorded as span links.
+func exportSpans(ctx context.Context, exp *telemetry.Exporter, rootDir string, run *RunRecord, runErr error) error {
+	traceID := telemetry.NewTraceID()
+	root := telemetry.Span{
+		TraceID: traceID,
+		SpanID:  telemetry.NewSpanID(),
+		Name:    "krill run " + run.Target,
+		Start:   run.Started,
+		End:     run.Finished,
+		Attrs: map[string]any{
+			"krill.target": run.Target,
+			"krill.run_id": run.ID,
+			"krill.status": run.Status,
+		},
+	}
+
+	if runErr != nil {
+		root.Error = runErr.Error()
+	}
+
+	spans := []telemetry.Span{root}
+	projects := make(map[string]int)
+	targets := make(map[string]string, len(run.Targets))
+	for _, t := range run.Targets {
+		targets[t.Name] = telemetry.NewSpanID()
+	}
+
+	for _, t := range run.Targets {
+		rel, err := filepath.Rel(rootDir, t.Dir)
+		if err != nil {
+			rel = t.Dir
+		}
+		rel = filepath.ToSlash(rel)
+
+		parent := root.SpanID
+		if rel != "." {
+			i, ok := projects[rel]
+			if !ok {
+				i = len(spans)
+				projects[rel] = i
+				spans = append(spans, telemetry.Span{
+					TraceID:  traceID,
+					SpanID:   telemetry.NewSpanID(),
+					ParentID: root.SpanID,
+					Name:     "project " + rel,
+					Start:    t.Started,
+					End:      t.Finished,
+					Attrs:    map[string]any{"krill.project": rel},
+				})
+			}
+
+			project := &spans[i]
+			if t.Started.Before(project.Start) {
+				project.Start = t.Started
+			}
+
+			if t.Finished.After(project.End) {
+				project.End = t.Finished
+			}
+
+			if t.Status == StatusFailed {
+				project.Error = fmt.Sprintf("target %s failed", t.Name)
+			}
+
+			parent = project.SpanID
+		}
+
+		span := telemetry.Span{
+			TraceID:  traceID,
+			SpanID:   targets[t.Name],
+			ParentID: parent,
+			Name:     t.Name,
+			Start:    t.Started,
+			End:      t.Finished,
+			Attrs: map[string]any{
+				"krill.target":     t.Name,
+				"krill.project":    rel,
+				"krill.status":     t.Status,
+				"krill.cached":     t.Status == StatusCached,
+				"krill.up_to_date": t.Status == StatusUpToDate,
+			},
+		}
+
+		if len(t.DependsOn) > 0 {
+			span.Attrs["krill.depends_on"] = strings.Join(t.DependsOn, ",")
+		}
+
+		for _, d := range t.DependsOn {
+			if id, ok := targets[d]; ok {
+				span.Links = append(span.Links, id)
+			}
+		}
+
+		if t.Status == StatusFailed {
+			span.Error = "target failed"
+		}
+
+		spans = append(spans, span)
+
+		for _, c := range t.Commands {
+			cmd := telemetry.Span{
+				TraceID:  traceID,
+				SpanID:   telemetry.NewSpanID(),
+				ParentID: span.SpanID,
+				Name:     c.Command,
+				Start:    c.Started,
+				End:      c.Finished,
+				Attrs: map[string]any{
+					"krill.target":    t.Name,
+					"krill.command":   c.Command,
+					"krill.exit_code": c.ExitCode,
+				},
+				Error: c.Error,
+			}
+
+			spans = append(spans, cmd)
+		}
+	}
+
+	return exp.Export(ctx, spans)
+}
@@ -0,0 +1,169 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+
+	"github.com/kociumba/krill/artifact"
+	"github.com/kociumba/krill/cli_utils"
+	"github.com/kociumba/krill/config"
+)
+
+var PackageFormats = []string{"tar.gz", "zip"}
+
+type PackageOptions struct {
+	Target    string
+	OutDir    string
+	Formats   []string
+	SkipBuild bool
+	Run       Options
+}
+
+// Package builds a target and archives the artifacts of the target and its
+// dependencies as <name>-<version>-<os>-<arch>.<format>, recording the
+// checksums of the archives in checksums.txt next to them
+func Package(ctx context.Context, cfg *config.Cfg, opts PackageOptions) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	for _, format := range opts.Formats {
+		if !slices.Contains(PackageFormats, format) {
+			return fmt.Errorf("unknown archive format %q, expected tar.gz or zip", format)
+		}
+	}
+
+	if !opts.SkipBuild {
+		if err := buildTarget(ctx, cfg, []string{opts.Target}, opts.Run); err != nil {
+			return err
+		}
+	}
+
+	p, err := newPlan(cfg, wd, []string{opts.Target})
+	if err != nil {
+		return err
+	}
+
+	files, err := p.artifacts()
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return fmt.Errorf("target %s and its dependencies do not declare any artifacts", opts.Target)
+	}
+
+	mtime, err := artifact.ModTime()
+	if err != nil {
+		return err
+	}
+
+	outDir := resolvePath(wd, opts.OutDir)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", opts.OutDir, err)
+	}
+
+	base := archiveName(cfg, wd)
+	var names []string
+	for _, format := range opts.Formats {
+		name := base + "." + format
+		if err := artifact.Create(filepath.Join(outDir, name), files, artifact.Options{Prefix: base, ModTime: mtime}); err != nil {
+			return fmt.Errorf("failed to create %s: %w", name, err)
+		}
+
+		names = append(names, name)
+	}
+
+	if err := artifact.UpdateChecksums(outDir, names); err != nil {
+		return fmt.Errorf("failed to write %s: %w", artifact.ChecksumsFile, err)
+	}
+
+	for _, name := range names {
+		cli_utils.PrintSuccessMessage(fmt.Sprintf("Packaged %d files into %s", len(files), filepath.Join(opts.OutDir, name)))
+	}
+
+	return nil
+}
+
+func archiveName(cfg *config.Cfg, dir string) string {
+	name := cfg.Project.Name
+	if name == "" {
+		name = filepath.Base(dir)
+	}
+
+	version := cfg.Project.Version
+	if version == "" {
+		version = "0.0.0"
+	}
+
+	return fmt.Sprintf("%s-%s-%s-%s", name, version, runtime.GOOS, runtime.GOARCH)
+}
+
+// artifacts resolves the artifacts of every target in the plan, paths in the
+// archive are relative to the root project
+func (p *plan) artifacts() ([]artifact.File, error) {
+	var files []artifact.File
+	seen := make(map[string]bool)
+	for _, n := range p.order {
+		if !n.target.Supported() {
+			continue
+		}
+
+		for _, pattern := range n.target.Artifacts {
+			matches, err := expandGlobs(n.dir, []string{pattern})
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", p.label(n), err)
+			}
+
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: artifact %q does not match any files, was the target built?", p.label(n), pattern)
+			}
+
+			for _, m := range matches {
+				src := filepath.Join(n.dir, filepath.FromSlash(m))
+				if !within(p.dir, src) {
+					return nil, fmt.Errorf("%s: artifact %s is outside of the project %s", p.label(n), src, p.dir)
+				}
+
+				rel, err := filepath.Rel(p.dir, src)
+				if err != nil {
+					return nil, err
+				}
+
+				if !seen[rel] {
+					seen[rel] = true
+					files = append(files, artifact.File{Path: filepath.ToSlash(rel), Src: src})
+				}
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// VerifyPackages checks the archives in dir against its checksums.txt
+func VerifyPackages(dir string) error {
+	ok, failed, err := artifact.Verify(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range ok {
+		cli_utils.PrintSuccessMessage(fmt.Sprintf("%s: OK", name))
+	}
+
+	for _, m := range failed {
+		cli_utils.PrintErrorMessage(fmt.Sprintf("%s: %s", m.Name, m.Reason))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%d of %d files failed verification", len(failed), len(ok)+len(failed))
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package build
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/kociumba/krill/config"
+	"github.com/urfave/cli/v3"
+)
+
+// paramFlags turns the params of a target into flags of its run subcommand
+func paramFlags(params map[string]config.Param) []cli.Flag {
+	var flags []cli.Flag
+	for _, name := range sortedKeys(params) {
+		param := params[name]
+		if param.Validate(name) != nil || isRunFlag(name) {
+			// reported when the target is run
+			continue
+		}
+
+		switch param.Kind() {
+		case config.ParamBool:
+			flags = append(flags, &cli.BoolFlag{
+				Name:  name,
+				Value: param.DefaultValue().(bool),
+				Usage: param.Description,
+			})
+		case config.ParamInt:
+			flags = append(flags, &cli.IntFlag{
+				Name:  name,
+				Value: param.DefaultValue().(int),
+				Usage: param.Description,
+			})
+		case config.ParamEnum:
+			values := param.Values
+			flags = append(flags, &cli.StringFlag{
+				Name:  name,
+				Value: param.DefaultValue().(string),
+				Usage: strings.TrimSpace(fmt.Sprintf("%s (one of: %s)", param.Description, strings.Join(values, ", "))),
+				Validator: func(s string) error {
+					if !slices.Contains(values, s) {
+						return fmt.Errorf("invalid value %q for --%s, expected one of: %s", s, name, strings.Join(values, ", "))
+					}
+
+					return nil
+				},
+			})
+		default:
+			flags = append(flags, &cli.StringFlag{
+				Name:  name,
+				Value: param.DefaultValue().(string),
+				Usage: param.Description,
+			})
+		}
+	}
+
+	return flags
+}
+
+func validateParams(targetName string, params map[string]config.Param) error {
+	for _, name := range sortedKeys(params) {
+		if err := params[name].Validate(name); err != nil {
+			return fmt.Errorf("target %s: %w", targetName, err)
+		}
+
+		if isRunFlag(name) {
+			return fmt.Errorf("target %s: param %s conflicts with the --%s flag of krill run", targetName, name, name)
+		}
+	}
+
+	return nil
+}
+
+func isRunFlag(name string) bool {
+	for _, f := range RunFlags {
+		if slices.Contains(f.Names(), name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// paramValues reads the values of the params of a target set with its flags,
+// the others keep the default of the config
+func paramValues(cmd *cli.Command, params map[string]config.Param) map[string]any {
+	values := make(map[string]any, len(params))
+	for name, param := range params {
+		if !cmd.IsSet(name) {
+			continue
+		}
+
+		switch param.Kind() {
+		case config.ParamBool:
+			values[name] = cmd.Bool(name)
+		case config.ParamInt:
+			values[name] = int(cmd.Int(name))
+		default:
+			values[name] = cmd.String(name)
+		}
+	}
+
+	return values
+}
+
+// joinArgs joins passthrough arguments into a single string for the shell,
+// quoting the ones the shell would otherwise split or interpret
+func joinArgs(shell string, args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = config.QuoteArg(shell, arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// splitArgs separates the targets selected on the command line of cmd from
+// the arguments given after "--". The parser drops the separator, but the
+// arguments of the parent command still hold everything given to cmd, and
+// the arguments after it always end up at the end of the positional ones.
+func splitArgs(cmd *cli.Command) (targets, passthrough []string) {
+	args := cmd.Args().Slice()
+	lineage := cmd.Lineage()
+	if len(lineage) < 2 {
+		return args, nil
+	}
+
+	raw := lineage[1].Args().Tail()
+	i := slices.Index(raw, "--")
+	if i < 0 {
+		return args, nil
+	}
+
+	n := min(len(raw)-i-1, len(args))
+	return args[:len(args)-n], args[len(args)-n:]
+}
@@ -0,0 +1,90 @@
+package build
+
+import (
+	"context"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/urfave/cli/v3"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		argv        []string
+		targets     []string
+		passthrough []string
+	}{
+		{"no separator", []string{"run", "a", "b"}, []string{"a", "b"}, nil},
+		{"run itself", []string{"run", "a", "--", "-v", "x"}, []string{"a"}, []string{"-v", "x"}},
+		{"target subcommand", []string{"run", "-j", "2", "build", "a", "--", "x"}, []string{"a"}, []string{"x"}},
+		{"flags before the separator", []string{"run", "build", "--mode", "release", "--", "--mode"}, nil, []string{"--mode"}},
+		{"separator in the passthrough", []string{"run", "build", "--", "a", "--", "b"}, nil, []string{"a", "--", "b"}},
+		{"nothing after the separator", []string{"run", "build", "a", "--"}, []string{"a"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var targets, passthrough []string
+			action := func(_ context.Context, cmd *cli.Command) error {
+				targets, passthrough = splitArgs(cmd)
+				return nil
+			}
+
+			root := &cli.Command{
+				Name: "krill",
+				Commands: []*cli.Command{{
+					Name:   "run",
+					Flags:  []cli.Flag{&cli.IntFlag{Name: "jobs", Aliases: []string{"j"}}},
+					Action: action,
+					Commands: []*cli.Command{{
+						Name:   "build",
+						Flags:  []cli.Flag{&cli.StringFlag{Name: "mode"}},
+						Action: action,
+					}},
+				}},
+			}
+
+			if err := root.Run(context.Background(), append([]string{"krill"}, tt.argv...)); err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(targets, tt.targets) || !slices.Equal(passthrough, tt.passthrough) {
+				t.Errorf("splitArgs() = %q, %q, want %q, %q", targets, passthrough, tt.targets, tt.passthrough)
+			}
+		})
+	}
+}
+
+func TestJoinArgs(t *testing.T) {
+	args := []string{"-run", "TestA|TestB", "it's", ""}
+	tests := []struct {
+		shell string
+		want  string
+	}{
+		{"/bin/bash", `-run 'TestA|TestB' 'it'\''s' ''`},
+		{"sh", `-run 'TestA|TestB' 'it'\''s' ''`},
+		{"/usr/bin/fish", `-run 'TestA|TestB' 'it\'s' ''`},
+		{"powershell.exe", `-run 'TestA|TestB' 'it''s' ''`},
+		{"C:/Program Files/PowerShell/7/pwsh.exe", `-run 'TestA|TestB' 'it''s' ''`},
+		{"cmd.exe", `-run "TestA|TestB" "it's" ""`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell, func(t *testing.T) {
+			if got := joinArgs(tt.shell, args); got != tt.want {
+				t.Errorf("joinArgs(%q) = %s, want %s", tt.shell, got, tt.want)
+			}
+		})
+	}
+
+	want := `'it'\''s'`
+	if runtime.GOOS == "windows" {
+		want = `'it''s'`
+	}
+
+	if got := joinArgs("", []string{"it's"}); got != want {
+		t.Errorf("joinArgs() without a shell = %s, want %s", got, want)
+	}
+}
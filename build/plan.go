@@ -0,0 +1,399 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kociumba/krill/cache"
+	"github.com/kociumba/krill/config"
+	"github.com/kociumba/krill/templating"
+)
+
+// node is a single target in a single project, keyed the same way the old
+// recursive visited set was: "<project dir>-<target name>"
+type node struct {
+	key    string
+	name   string
+	dir    string
+	cfg    *config.Cfg
+	target config.BuildTarget
+
+	deps       []*node
+	dependents []*node
+
+	// variables set on top of the process environment, resolved right
+	// before the node runs
+	env map[string]string
+
+	// set once the node finished, read by dependents
+	fingerprint string
+
+	// the node, or one of its dependencies, ran without a fingerprint, so
+	// its dependents can not tell whether its results changed
+	untracked bool
+
+	// what happened during the run, nil until the node is started
+	record *TargetRecord
+}
+
+type plan struct {
+	dir   string  // root project directory
+	roots []*node // the selected targets, in the order they were given
+	nodes map[string]*node
+	order []*node // topological, dependencies first
+
+	// skip nested projects missing an aggregate target instead of failing,
+	// used when showing the whole graph
+	lenient bool
+
+	configs     map[string]*config.Cfg
+	detectedEnv map[string]bool
+	state       *stateStore
+	cache       *cache.Cache
+	logs        *runLog
+
+	// how long cancelled commands get to exit before they are killed
+	gracePeriod time.Duration
+
+	// runs a single node, runNode unless replaced in tests of the scheduler
+	run func(ctx context.Context, n *node, opts Options) error
+}
+
+func newGraph(cfg *config.Cfg, dir string) *plan {
+	return &plan{
+		dir:         dir,
+		nodes:       make(map[string]*node),
+		configs:     map[string]*config.Cfg{dir: cfg},
+		detectedEnv: make(map[string]bool),
+		state:       newStateStore(),
+		gracePeriod: defaultGracePeriod,
+	}
+}
+
+// newPlan resolves the graph of one or more targets of the project, shared
+// dependencies are added only once
+func newPlan(cfg *config.Cfg, dir string, targets []string) (*plan, error) {
+	p := newGraph(cfg, dir)
+
+	for _, targetName := range targets {
+		rootCfg, rootDir, rootName := cfg, dir, targetName
+		if project, name, ok := p.parseRef(cfg, dir, targetName); ok {
+			var err error
+			rootCfg, err = p.loadConfig(project)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load project of %s: %w", targetName, err)
+			}
+
+			rootDir, rootName = project, name
+		}
+
+		root, err := p.add(rootCfg, rootDir, rootName, make(map[string]struct{}))
+		if err != nil {
+			return nil, err
+		}
+
+		if !slices.Contains(p.roots, root) {
+			p.roots = append(p.roots, root)
+		}
+	}
+
+	for _, n := range p.order {
+		if n.target.HasCommands() && n.target.Supported() {
+			if err := p.ensureEnv(n.cfg, n.dir); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// addAll adds every target of the project and of all its nested projects
+func (p *plan) addAll(cfg *config.Cfg, dir string) error {
+	for _, name := range sortedKeys(cfg.BuildTargets) {
+		if _, err := p.add(cfg, dir, name, make(map[string]struct{})); err != nil {
+			return err
+		}
+	}
+
+	order, err := nestedOrder(cfg)
+	if err != nil {
+		return err
+	}
+
+	for _, subPath := range order {
+		subDir := filepath.Join(dir, subPath)
+		subCfg, err := p.loadConfig(subDir)
+		if err != nil {
+			return fmt.Errorf("failed to load nested config at %s: %w", subPath, err)
+		}
+
+		if err := p.addAll(subCfg, subDir); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *plan) add(cfg *config.Cfg, dir, targetName string, visiting map[string]struct{}) (*node, error) {
+	key := dir + "-" + targetName
+	if n, ok := p.nodes[key]; ok {
+		return n, nil
+	}
+
+	if _, seen := visiting[key]; seen {
+		return nil, fmt.Errorf("cycle detected at %s for target %s", dir, targetName)
+	}
+
+	target, ok := cfg.BuildTargets[targetName]
+	if !ok {
+		return nil, fmt.Errorf("Target %s does not exist in the project", targetName)
+	}
+
+	visiting[key] = struct{}{}
+	defer delete(visiting, key)
+
+	n := &node{
+		key:    key,
+		name:   targetName,
+		dir:    dir,
+		cfg:    cfg,
+		target: target,
+	}
+
+	for _, dep := range target.DependsOn {
+		depCfg, depDir, depName := cfg, dir, dep
+		if project, name, ok := p.parseRef(cfg, dir, dep); ok {
+			var err error
+			depCfg, err = p.loadConfig(project)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load project of dependency %s: %w", dep, err)
+			}
+
+			depDir, depName = project, name
+		}
+
+		d, err := p.add(depCfg, depDir, depName, visiting)
+		if err != nil {
+			return nil, fmt.Errorf("dependency %s failed: %w", dep, err)
+		}
+
+		n.link(d)
+	}
+
+	if isAggregate(target) && !isToolSpecific(cfg, targetName) {
+		order, err := nestedOrder(cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		projects := make(map[string]*node, len(order))
+		for _, subPath := range order {
+			subNested := cfg.Nested[subPath]
+			subDir := filepath.Join(dir, subPath)
+			subCfg, err := p.loadConfig(subDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load nested config at %s: %w", subPath, err)
+			}
+
+			subTarget := targetName
+			if mapping, ok := subNested.Mappings[targetName]; ok {
+				subTarget = mapping
+			}
+
+			if _, ok := subCfg.BuildTargets[subTarget]; !ok && p.lenient {
+				continue
+			}
+
+			d, err := p.add(subCfg, subDir, subTarget, visiting)
+			if err != nil {
+				return nil, fmt.Errorf("failed building nested %s: %w", subPath, err)
+			}
+
+			// order is topological, so every project this one depends on
+			// already has its node
+			for _, dep := range subNested.DependsOn {
+				if other, ok := projects[dep]; ok {
+					if reachable(other, func(n *node) []*node { return n.deps })[d] {
+						return nil, fmt.Errorf("cycle detected between nested projects %s and %s", subPath, dep)
+					}
+
+					d.link(other)
+				}
+			}
+
+			projects[subPath] = d
+			n.link(d)
+		}
+	}
+
+	p.nodes[key] = n
+	p.order = append(p.order, n)
+	return n, nil
+}
+
+// parseRef resolves a dependency on a target of another project, written as
+// "<path>:<target>" relative to the project of the dependent target, or as
+// "//<path>:<target>" relative to the root project. A target of cfg with
+// that exact name always wins, target names may contain ":" as well.
+func (p *plan) parseRef(cfg *config.Cfg, dir, dep string) (project, name string, ok bool) {
+	if _, ok := cfg.BuildTargets[dep]; ok || !isRef(dir, dep) {
+		return "", "", false
+	}
+
+	i := strings.LastIndex(dep, ":")
+	path, name := dep[:i], dep[i+1:]
+	if rooted, ok := strings.CutPrefix(path, "//"); ok {
+		return filepath.Join(p.dir, filepath.FromSlash(rooted)), name, true
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(path)), name, true
+}
+
+// isRef reports whether dep names a target of another project, its path has
+// to start with "//", "./" or "../", or be an existing directory
+func isRef(dir, dep string) bool {
+	i := strings.LastIndex(dep, ":")
+	if i < 0 {
+		return false
+	}
+
+	path := dep[:i]
+	if path == "." || path == ".." || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		return true
+	}
+
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path)))
+	return err == nil && info.IsDir()
+}
+
+func (n *node) link(dep *node) {
+	if slices.Contains(n.deps, dep) {
+		return
+	}
+
+	n.deps = append(n.deps, dep)
+	dep.dependents = append(dep.dependents, n)
+}
+
+func (p *plan) loadConfig(dir string) (*config.Cfg, error) {
+	if cfg, ok := p.configs[dir]; ok {
+		return cfg, nil
+	}
+
+	raw, err := config.GetConfigFromDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := templating.ExpandConfigAt(dir, raw, "", "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not expand templating arguments in %s: %w", config.ConfigPath(dir), err)
+	}
+
+	p.configs[dir] = &cfg
+	return &cfg, nil
+}
+
+// ensureEnv detects a default environment for projects that do not define
+// one for the current platform, this has to happen before any target runs,
+// since targets of the same project may run concurrently
+func (p *plan) ensureEnv(cfg *config.Cfg, dir string) error {
+	if cfg.Env[runtime.GOOS].Path != "" {
+		return nil
+	}
+
+	env, err := config.DetectEnvironment(
+		slices.Contains(cfg.Project.Languages, config.C) ||
+			slices.Contains(cfg.Project.Languages, config.Cpp))
+	if err != nil {
+		return err
+	}
+
+	if cfg.Env == nil {
+		cfg.Env = make(map[string]config.Environment)
+	}
+
+	// keep variables and env files of a partially defined environment
+	cur := cfg.Env[runtime.GOOS]
+	cur.Path, cur.Args = env.Path, env.Args
+	cfg.Env[runtime.GOOS] = cur
+	p.detectedEnv[dir] = true
+	return nil
+}
+
+// nestedOrder sorts the nested projects of a config so every project comes
+// after the projects it depends on, ties are broken by path
+func nestedOrder(cfg *config.Cfg) ([]string, error) {
+	var order []string
+	state := make(map[string]int) // 1 visiting, 2 done
+
+	var visit func(subPath string) error
+	visit = func(subPath string) error {
+		switch state[subPath] {
+		case 1:
+			return fmt.Errorf("cycle detected in depends_on of nested project %s", subPath)
+		case 2:
+			return nil
+		}
+
+		state[subPath] = 1
+		deps := slices.Clone(cfg.Nested[subPath].DependsOn)
+		slices.Sort(deps)
+		for _, dep := range deps {
+			if _, ok := cfg.Nested[dep]; !ok {
+				return fmt.Errorf("nested project %s depends on %s, which is not a nested project", subPath, dep)
+			}
+
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		state[subPath] = 2
+		order = append(order, subPath)
+		return nil
+	}
+
+	for _, subPath := range sortedKeys(cfg.Nested) {
+		if err := visit(subPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// isAggregate reports whether the target only groups the same target of the
+// nested projects, the aggregate of a matrix only groups its combinations
+func isAggregate(target config.BuildTarget) bool {
+	return len(target.DependsOn) > 0 && len(target.Commands) == 0 && target.OutputDir == "" && !target.MatrixAggregate
+}
+
+func isToolSpecific(cfg *config.Cfg, targetName string) bool {
+	parts := strings.Split(targetName, "-")
+	if len(parts) < 2 {
+		return false
+	}
+
+	base := parts[0]
+	suffix := strings.Join(parts[1:], "-")
+	if base != "debug" && base != "release" {
+		return false
+	}
+
+	for _, tool := range cfg.Project.Tools {
+		if strings.ToLower(tool.String()) == suffix {
+			return true
+		}
+	}
+
+	return false
+}
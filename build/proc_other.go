@@ -0,0 +1,35 @@
+//go:build !unix
+
+package build
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// processGroup only kills the direct child on platforms without process
+// groups. Signals are not forwarded and there is no grace period, a cancelled
+// command is killed right away, processes it started keep running. WaitDelay
+// makes sure krill does not hang on them.
+type processGroup struct {
+	cmd *exec.Cmd
+}
+
+func newProcessGroup(ctx context.Context, cmd *exec.Cmd, grace time.Duration) *processGroup {
+	cmd.Cancel = func() error {
+		cmd.WaitDelay = grace
+		return cmd.Process.Kill()
+	}
+
+	cmd.WaitDelay = leftoverOutputDelay
+	return &processGroup{cmd: cmd}
+}
+
+func (g *processGroup) release() {}
+
+func (g *processGroup) reap() {}
+
+func (g *processGroup) kill() {
+	g.cmd.Process.Kill()
+}
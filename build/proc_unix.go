@@ -0,0 +1,154 @@
+//go:build unix
+
+package build
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// processGroup runs a command in its own process group, so a cancelled
+// command takes everything it started down with it, not just the shell.
+// When krill runs in the foreground of a terminal, the terminal is handed to
+// one command at a time, like a shell does for its jobs, so the command can
+// read from it and gets Ctrl+C directly.
+type processGroup struct {
+	cmd        *exec.Cmd
+	grace      time.Duration
+	foreground bool
+	cancelled  time.Time
+}
+
+// terminal is held by at most one command at a time, the others run in the
+// background without input
+var terminal struct {
+	sync.Mutex
+	owner *processGroup
+}
+
+func newProcessGroup(ctx context.Context, cmd *exec.Cmd, grace time.Duration) *processGroup {
+	g := &processGroup{cmd: cmd, grace: grace}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if cmd.Stdin == os.Stdin && stdinIsTerminal() {
+		if g.acquireTerminal() {
+			g.foreground = true
+			cmd.SysProcAttr.Foreground = true
+			cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+		} else {
+			// reading from the terminal would stop a background command
+			cmd.Stdin = nil
+		}
+	}
+
+	cmd.Cancel = func() error {
+		g.cancelled = time.Now()
+		cmd.WaitDelay = grace
+		sig, ok := interruptSignal(ctx).(syscall.Signal)
+		if !ok {
+			sig = syscall.SIGINT
+		}
+
+		return syscall.Kill(-cmd.Process.Pid, sig)
+	}
+
+	cmd.WaitDelay = leftoverOutputDelay
+	return g
+}
+
+// acquireTerminal hands the terminal to the command, if krill itself is in
+// the foreground and no other command has it
+func (g *processGroup) acquireTerminal() bool {
+	terminal.Lock()
+	defer terminal.Unlock()
+
+	if terminal.owner != nil {
+		return false
+	}
+
+	if !inForeground(int(os.Stdin.Fd())) {
+		return false
+	}
+
+	terminal.owner = g
+	return true
+}
+
+// release takes the terminal back from the command once it exited. A Ctrl+C
+// pressed while the command had the terminal only reached the command, so
+// krill interrupts the rest of the build itself.
+func (g *processGroup) release() {
+	if !g.foreground {
+		return
+	}
+
+	terminal.Lock()
+	defer terminal.Unlock()
+	if terminal.owner != g {
+		return
+	}
+
+	terminal.owner = nil
+
+	// krill is in the background now, taking the terminal back would stop it
+	// with SIGTTOU, no command may start while the signal is ignored, or it
+	// would ignore it as well
+	syscall.ForkLock.Lock()
+	signal.Ignore(syscall.SIGTTOU)
+	takeTerminal(int(os.Stdin.Fd()))
+	signal.Reset(syscall.SIGTTOU)
+	syscall.ForkLock.Unlock()
+
+	if g.cmd.ProcessState == nil || !g.cancelled.IsZero() {
+		return
+	}
+
+	if ws, ok := g.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGINT {
+		// whatever is left in the group is reaped like after a cancel
+		g.cancelled = time.Now()
+		interrupt(os.Interrupt)
+	}
+}
+
+// reap waits for the rest of the group to exit after a cancelled command
+// returned, and kills it once the grace period is over
+func (g *processGroup) reap() {
+	if g.cancelled.IsZero() || g.cmd.Process == nil {
+		return
+	}
+
+	pgid := g.cmd.Process.Pid
+	deadline := g.cancelled.Add(g.grace)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(-pgid, 0); errors.Is(err, syscall.ESRCH) {
+			return
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	syscall.Kill(-pgid, syscall.SIGKILL)
+}
+
+// kill stops the command and everything it started right away
+func (g *processGroup) kill() {
+	syscall.Kill(-g.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// stdinIsTerminal reports whether commands could read from a terminal,
+// /dev/null is a character device as well
+func stdinIsTerminal() bool {
+	fi, err := os.Stdin.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(fi, null)
+}
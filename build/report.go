@@ -0,0 +1,64 @@
+package build
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/kociumba/krill/cli_utils"
+)
+
+// printFailures prints every failed target of a keep going run, with the
+// command that failed and where its output was logged
+func (p *plan) printFailures(failures []result, skipped []*node) {
+	fmt.Println()
+	cli_utils.PrintHeader(fmt.Sprintf("%d targets failed", len(failures)), cli_utils.ColorRed)
+
+	for _, f := range failures {
+		cli_utils.PrintErrorMessage(p.label(f.node))
+
+		cmd := failedCommand(f.node.record)
+		if cmd == nil {
+			fmt.Printf("    %s\n", f.err)
+			continue
+		}
+
+		fmt.Printf("    command:   %s\n", cmd.Command)
+		fmt.Printf("    exit code: %d\n", cmd.ExitCode)
+		if p.logs != nil && p.logs.dir != "" {
+			log := filepath.Join(p.logs.dir, filepath.FromSlash(cmd.Log))
+			if rel, err := filepath.Rel(p.dir, log); err == nil {
+				log = rel
+			}
+
+			fmt.Printf("    log:       %s\n", log)
+		}
+	}
+
+	if len(skipped) > 0 {
+		names := make([]string, len(skipped))
+		for i, n := range skipped {
+			names[i] = p.label(n)
+		}
+
+		fmt.Println()
+		cli_utils.PrintWarningMessage(fmt.Sprintf("Skipped because a dependency failed: %s", strings.Join(names, ", ")))
+	}
+}
+
+// failedCommand is the command that failed a target, later failures of
+// on_failure and finally hooks are not reported, nil if the target failed
+// without a failing command
+func failedCommand(t *TargetRecord) *CommandRecord {
+	if t == nil {
+		return nil
+	}
+
+	for _, c := range t.Commands {
+		if c.Failed {
+			return c
+		}
+	}
+
+	return nil
+}
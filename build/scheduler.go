@@ -0,0 +1,274 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kociumba/krill/cli_utils"
+)
+
+type Options struct {
+	Jobs      int
+	Force     bool
+	DryRun    bool
+	KeepGoing bool
+	Grace     time.Duration // grace period of interrupted commands
+	Trace     string        // path of the trace file, none if empty
+}
+
+type result struct {
+	node *node
+	err  error
+}
+
+// execute runs every node in the plan exactly once, starting a node only
+// after all of its dependencies succeeded, with at most opts.Jobs nodes
+// running at the same time. After the first failure no new nodes are
+// started, the ones already running are drained and the failure is returned.
+// With opts.KeepGoing only nodes downstream of a failure are skipped, and all
+// failures are reported together once nothing else can run.
+func (p *plan) execute(ctx context.Context, opts Options) error {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = runtime.NumCPU()
+	}
+
+	run := p.run
+	if run == nil {
+		run = p.runNode
+	}
+
+	pending := make(map[*node]int, len(p.order))
+	var ready []*node
+	for _, n := range p.order {
+		pending[n] = len(n.deps)
+		if len(n.deps) == 0 {
+			ready = append(ready, n)
+		}
+	}
+
+	results := make(chan result)
+	running := 0
+	var firstErr error
+	var failures []result
+	started := make(map[*node]bool, len(p.order))
+
+	for {
+		for firstErr == nil && ctx.Err() == nil && running < jobs && len(ready) > 0 {
+			n := ready[0]
+			ready = ready[1:]
+			running++
+			started[n] = true
+
+			go func(n *node) {
+				results <- result{node: n, err: run(ctx, n, opts)}
+			}(n)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		r := <-results
+		running--
+
+		if r.err != nil {
+			// dependents of a failed node never become ready
+			if opts.KeepGoing {
+				failures = append(failures, r)
+				continue
+			}
+
+			if firstErr == nil {
+				firstErr = p.wrapErr(r.node, r.err)
+			}
+
+			continue
+		}
+
+		for _, d := range r.node.dependents {
+			pending[d]--
+			if pending[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+
+	if len(failures) > 0 && ctx.Err() != nil {
+		firstErr = p.wrapErr(failures[0].node, failures[0].err)
+	} else if len(failures) > 0 {
+		skipped := filterNodes(p.order, func(n *node) bool { return !started[n] })
+		p.printFailures(failures, skipped)
+		firstErr = fmt.Errorf("%d of %d targets failed, %d skipped", len(failures), len(p.order), len(skipped))
+	}
+
+	if err := p.state.save(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+// runNode runs a single target unless its fingerprint matches the one
+// recorded by the last successful run
+func (p *plan) runNode(ctx context.Context, n *node, opts Options) (err error) {
+	var rec *TargetRecord
+	status := StatusOK
+	if p.logs != nil {
+		deps := make([]string, len(n.deps))
+		for i, d := range n.deps {
+			deps[i] = p.label(d)
+		}
+
+		rec = p.logs.startTarget(p.label(n), n.dir, deps)
+		n.record = rec
+		defer func() {
+			if interrupted(ctx) {
+				status = StatusInterrupted
+			} else if err != nil {
+				status = StatusFailed
+			}
+
+			p.logs.finishTarget(rec, status)
+		}()
+	}
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+
+	if !n.target.Supported() {
+		cli_utils.PrintInfoMessage(fmt.Sprintf("Skipped %s: only runs on %s", p.label(n), n.target.PlatformDescription()))
+		status = StatusSkipped
+		n.untracked = slices.ContainsFunc(n.deps, func(d *node) bool { return d.untracked })
+		return nil
+	}
+
+	env, err := n.resolveEnv()
+	if err != nil {
+		return err
+	}
+
+	n.env = env
+
+	fp, err := n.computeFingerprint()
+	if err != nil {
+		return fmt.Errorf("failed to hash inputs: %w", err)
+	}
+
+	if fp != "" && !opts.Force {
+		if st, ok := p.state.get(n.dir, n.name); ok && st.Fingerprint == fp {
+			exist, err := n.outputsExist()
+			if err != nil {
+				return err
+			}
+
+			if exist {
+				fmt.Println("Up to date:", p.label(n))
+				status = StatusUpToDate
+				n.fingerprint = fp
+				return nil
+			}
+		}
+
+		if p.cache != nil && n.cacheable() {
+			if restored := p.restoreFromCache(n, fp); restored {
+				fmt.Println("Restored from cache:", p.label(n))
+				status = StatusCached
+				p.state.set(n.dir, n.name, targetState{Fingerprint: fp})
+				n.fingerprint = fp
+				return nil
+			}
+		}
+	}
+
+	if err := p.runTarget(ctx, n, rec); err != nil {
+		return err
+	}
+
+	if fp != "" {
+		p.state.set(n.dir, n.name, targetState{Fingerprint: fp})
+
+		if p.cache != nil && n.cacheable() {
+			p.storeInCache(n, fp)
+		}
+	}
+
+	n.fingerprint = fp
+	n.untracked = fp == ""
+	return nil
+}
+
+func (p *plan) wrapErr(n *node, err error) error {
+	if slices.Contains(p.roots, n) {
+		return err
+	}
+
+	if errors.Is(err, ErrInterrupted) {
+		return fmt.Errorf("%s: %w", p.label(n), err)
+	}
+
+	return fmt.Errorf("dependency %s failed: %w", p.label(n), err)
+}
+
+// label names a node relative to the root project, nested targets are
+// prefixed with the path of their project
+func (p *plan) label(n *node) string {
+	if n.dir == p.dir {
+		return n.name
+	}
+
+	rel, err := filepath.Rel(p.dir, n.dir)
+	if err != nil {
+		rel = n.dir
+	}
+
+	return filepath.ToSlash(rel) + ":" + n.name
+}
+
+// rootLabels names the selected targets, for messages
+func (p *plan) rootLabels() string {
+	labels := make([]string, len(p.roots))
+	for i, n := range p.roots {
+		labels[i] = p.label(n)
+	}
+
+	return strings.Join(labels, ", ")
+}
+
+// cache failures never fail a build, the target simply runs or is not stored
+func (p *plan) restoreFromCache(n *node, key string) bool {
+	m, ok, err := p.cache.Get(key)
+	if err != nil {
+		cli_utils.PrintWarningMessage(fmt.Sprintf("cache lookup for %s failed: %v", p.label(n), err))
+		return false
+	}
+
+	if !ok {
+		return false
+	}
+
+	if err := p.cache.Restore(m, n.dir); err != nil {
+		cli_utils.PrintWarningMessage(fmt.Sprintf("cache restore for %s failed: %v", p.label(n), err))
+		return false
+	}
+
+	return true
+}
+
+func (p *plan) storeInCache(n *node, key string) {
+	files, err := n.outputFiles()
+	if err == nil {
+		err = p.cache.Put(key, n.dir, files)
+	}
+
+	if err != nil {
+		cli_utils.PrintWarningMessage(fmt.Sprintf("caching outputs of %s: %v", p.label(n), err))
+	}
+}
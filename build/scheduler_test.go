@@ -0,0 +1,200 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kociumba/krill/config"
+)
+
+type testNode struct {
+	name string
+	deps []string
+}
+
+// testPlan builds a plan of nodes without targets, nodes have to come after
+// their dependencies
+func testPlan(t *testing.T, nodes []testNode, roots []string) *plan {
+	p := newGraph(&config.Cfg{}, t.TempDir())
+	for _, tn := range nodes {
+		n := &node{key: tn.name, name: tn.name, dir: p.dir}
+		for _, name := range tn.deps {
+			dep := p.nodes[name]
+			n.deps = append(n.deps, dep)
+			dep.dependents = append(dep.dependents, n)
+		}
+
+		p.nodes[n.key] = n
+		p.order = append(p.order, n)
+	}
+
+	for _, name := range roots {
+		p.roots = append(p.roots, p.nodes[name])
+	}
+
+	return p
+}
+
+func TestExecute(t *testing.T) {
+	diamond := []testNode{
+		{"gen", nil},
+		{"lib", []string{"gen"}},
+		{"tool", []string{"gen"}},
+		{"app", []string{"lib", "tool"}},
+	}
+
+	tests := []struct {
+		name      string
+		nodes     []testNode
+		roots     []string
+		jobs      int
+		keepGoing bool
+		fail      []string // nodes returning an error
+		slow      []string // nodes taking a while, so others finish first
+		ran       []string // nodes that finished running, sorted
+		err       string
+		parallel  int // most nodes running at the same time, if checked
+	}{
+		{
+			name:  "shared dependencies run once",
+			nodes: diamond,
+			roots: []string{"app", "lib"},
+			jobs:  4,
+			ran:   []string{"app", "gen", "lib", "tool"},
+		},
+		{
+			name:     "at most jobs nodes at once",
+			nodes:    []testNode{{"a", nil}, {"b", nil}, {"c", nil}, {"d", nil}},
+			roots:    []string{"a", "b", "c", "d"},
+			jobs:     2,
+			slow:     []string{"a", "b", "c", "d"},
+			ran:      []string{"a", "b", "c", "d"},
+			parallel: 2,
+		},
+		{
+			name:  "fail fast drains running nodes and starts nothing new",
+			nodes: []testNode{{"a", nil}, {"b", nil}, {"c", nil}},
+			roots: []string{"a", "b", "c"},
+			jobs:  2,
+			fail:  []string{"a"},
+			slow:  []string{"b"},
+			ran:   []string{"a", "b"},
+			err:   "a failed",
+		},
+		{
+			name:  "failed dependency",
+			nodes: diamond,
+			roots: []string{"app"},
+			jobs:  1,
+			fail:  []string{"lib"},
+			ran:   []string{"gen", "lib"},
+			err:   "dependency lib failed: lib failed",
+		},
+		{
+			name: "keep going skips only dependents of a failure",
+			nodes: []testNode{
+				{"gen", nil},
+				{"lib", []string{"gen"}},
+				{"docs", nil},
+				{"site", []string{"docs"}},
+				{"all", []string{"lib", "site"}},
+			},
+			roots:     []string{"all"},
+			jobs:      2,
+			keepGoing: true,
+			fail:      []string{"gen"},
+			ran:       []string{"docs", "gen", "site"},
+			err:       "1 of 5 targets failed, 2 skipped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testPlan(t, tt.nodes, tt.roots)
+
+			var mu sync.Mutex
+			var ran []string
+			running, parallel := 0, 0
+			finished := make(map[*node]bool)
+			p.run = func(ctx context.Context, n *node, opts Options) error {
+				mu.Lock()
+				for _, d := range n.deps {
+					if !finished[d] {
+						t.Errorf("%s started before its dependency %s finished", n.name, d.name)
+					}
+				}
+
+				running++
+				parallel = max(parallel, running)
+				mu.Unlock()
+
+				if slices.Contains(tt.slow, n.name) {
+					time.Sleep(50 * time.Millisecond)
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				running--
+				finished[n] = true
+				ran = append(ran, n.name)
+
+				if slices.Contains(tt.fail, n.name) {
+					return fmt.Errorf("%s failed", n.name)
+				}
+
+				return nil
+			}
+
+			err := p.execute(context.Background(), Options{Jobs: tt.jobs, KeepGoing: tt.keepGoing})
+			if tt.err == "" && err != nil {
+				t.Fatalf("execute() error = %v", err)
+			}
+
+			if tt.err != "" && (err == nil || err.Error() != tt.err) {
+				t.Errorf("execute() error = %v, want %q", err, tt.err)
+			}
+
+			slices.Sort(ran)
+			if !slices.Equal(ran, tt.ran) {
+				t.Errorf("ran %q, want %q", ran, tt.ran)
+			}
+
+			if tt.parallel > 0 && parallel != tt.parallel {
+				t.Errorf("%d nodes ran at once, want %d", parallel, tt.parallel)
+			}
+		})
+	}
+}
+
+func TestExecuteReturnsRootError(t *testing.T) {
+	p := testPlan(t, []testNode{{"test", nil}}, []string{"test"})
+	want := errors.New("exit status 2")
+	p.run = func(context.Context, *node, Options) error {
+		return want
+	}
+
+	if err := p.execute(context.Background(), Options{Jobs: 1}); err != want {
+		t.Errorf("execute() error = %v, want the error of the root itself", err)
+	}
+}
+
+func TestExecuteInterrupted(t *testing.T) {
+	p := testPlan(t, []testNode{{"a", nil}, {"b", []string{"a"}}}, []string{"b"})
+	ctx, cancel := context.WithCancelCause(context.Background())
+	p.run = func(ctx context.Context, n *node, opts Options) error {
+		cancel(&InterruptError{Signal: os.Interrupt})
+		return context.Cause(ctx)
+	}
+
+	err := p.execute(ctx, Options{Jobs: 1, KeepGoing: true})
+	if !errors.Is(err, ErrInterrupted) || !strings.HasPrefix(err.Error(), "a: ") {
+		t.Errorf("execute() error = %v, want a: interrupted", err)
+	}
+}
@@ -0,0 +1,168 @@
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/kociumba/krill/cli_utils"
+)
+
+const defaultGracePeriod = 5 * time.Second
+
+// leftoverOutputDelay is how long output of a command is still read after it
+// exited, background processes it started may keep the output open much
+// longer. Once a command is cancelled, the grace period is used instead.
+const leftoverOutputDelay = 200 * time.Millisecond
+
+// repeatSignalWindow is how long signals after the first one are ignored,
+// timeout and CI runners often signal krill and its process group at once,
+// which has to stop the build just like a single signal
+const repeatSignalWindow = time.Second
+
+// ErrInterrupted is returned by targets stopped by SIGINT or SIGTERM
+var ErrInterrupted = errors.New("interrupted")
+
+// InterruptError is the cancel cause of the context returned by
+// HandleSignals, it carries the signal so it can be forwarded to commands
+type InterruptError struct {
+	Signal os.Signal
+}
+
+func (e *InterruptError) Error() string {
+	return fmt.Sprintf("received %s", e.Signal)
+}
+
+func (e *InterruptError) Unwrap() error {
+	return ErrInterrupted
+}
+
+// HandleSignals returns a context cancelled on the first SIGINT or SIGTERM,
+// a second one kills every running command and exits krill right away, unless
+// it arrived within repeatSignalWindow of the first
+func HandleSignals(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	sigs := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	interruptBuild := func(sig os.Signal) {
+		cancel(&InterruptError{Signal: sig})
+	}
+	onInterrupt.Store(&interruptBuild)
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			cancel(&InterruptError{Signal: sig})
+		case <-ctx.Done():
+			// a command got the signal from the terminal
+		case <-done:
+			return
+		}
+
+		first := time.Now()
+		for {
+			select {
+			case <-sigs:
+				if time.Since(first) < repeatSignalWindow {
+					continue
+				}
+
+				cli_utils.PrintWarningMessage("Interrupted again, killing all running commands")
+				killRunning()
+				os.Exit(130)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			onInterrupt.Store(nil)
+			signal.Stop(sigs)
+			close(done)
+			cancel(nil)
+		})
+	}
+}
+
+// onInterrupt cancels the context of HandleSignals like a signal would, for
+// signals only a command received
+var onInterrupt atomic.Pointer[func(os.Signal)]
+
+func interrupt(sig os.Signal) {
+	if f := onInterrupt.Load(); f != nil {
+		(*f)(sig)
+	}
+}
+
+// running holds the commands started by this process, so a second signal can
+// kill them before krill exits, in their own process groups they would not
+// get the signal from the terminal
+var running = struct {
+	sync.Mutex
+	groups map[*processGroup]struct{}
+}{groups: make(map[*processGroup]struct{})}
+
+// run starts the command and waits for it and, if it was cancelled, the rest
+// of its process group
+func (g *processGroup) run() error {
+	if err := g.cmd.Start(); err != nil {
+		g.release()
+		return err
+	}
+
+	running.Lock()
+	running.groups[g] = struct{}{}
+	running.Unlock()
+
+	defer func() {
+		running.Lock()
+		delete(running.groups, g)
+		running.Unlock()
+	}()
+
+	err := g.cmd.Wait()
+	g.release()
+	g.reap()
+
+	// a process left running in the background is not a failure
+	if errors.Is(err, exec.ErrWaitDelay) && g.cmd.ProcessState.Success() {
+		return nil
+	}
+
+	return err
+}
+
+func killRunning() {
+	running.Lock()
+	defer running.Unlock()
+
+	for g := range running.groups {
+		g.kill()
+	}
+}
+
+// interruptSignal is the signal forwarded to running commands
+func interruptSignal(ctx context.Context) os.Signal {
+	var ie *InterruptError
+	if errors.As(context.Cause(ctx), &ie) {
+		return ie.Signal
+	}
+
+	return os.Interrupt
+}
+
+func interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrInterrupted)
+}
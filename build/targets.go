@@ -0,0 +1,238 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/kociumba/krill/cli_utils"
+	"github.com/kociumba/krill/config"
+)
+
+type TargetsOptions struct {
+	JSON bool // print the targets as json
+	All  bool // include hidden targets in the table
+}
+
+type targetInfo struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	DependsOn   []string `json:"depends_on"`
+	Platforms   []string `json:"platforms,omitempty"`
+	Arch        []string `json:"arch,omitempty"`
+	Applicable  bool     `json:"applicable"`
+	Hidden      bool     `json:"hidden"`
+	Default     bool     `json:"default"`
+}
+
+// ListTargets prints the targets of the project that can be passed to krill
+// run, sorted by name
+func ListTargets(cfg *config.Cfg, opts TargetsOptions) error {
+	def := DefaultTarget(cfg)
+	infos := []targetInfo{}
+	for _, name := range sortedKeys(cfg.BuildTargets) {
+		t := cfg.BuildTargets[name]
+		infos = append(infos, targetInfo{
+			Name:        name,
+			Description: t.Description,
+			Aliases:     t.Aliases,
+			Tags:        t.Tags,
+			DependsOn:   append([]string{}, t.DependsOn...),
+			Platforms:   t.Platforms,
+			Arch:        t.Arch,
+			Applicable:  t.Supported(),
+			Hidden:      t.Hidden,
+			Default:     name == def,
+		})
+	}
+
+	if opts.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(infos)
+	}
+
+	var rows []cli_utils.TableRow
+	for _, t := range infos {
+		if t.Hidden && !opts.All {
+			continue
+		}
+
+		name := t.Name
+		if t.Default {
+			name += " *"
+		}
+
+		description := t.Description
+		if len(t.Aliases) > 0 {
+			description = strings.TrimSpace(fmt.Sprintf("%s (aliases: %s)", description, strings.Join(t.Aliases, ", ")))
+		}
+
+		if len(t.Tags) > 0 {
+			description = strings.TrimSpace(fmt.Sprintf("%s (tags: %s)", description, strings.Join(t.Tags, ", ")))
+		}
+
+		color := ""
+		if !t.Applicable {
+			color = cli_utils.ColorGray
+			description = strings.TrimSpace(fmt.Sprintf("%s [only %s]", description, cfg.BuildTargets[t.Name].PlatformDescription()))
+		}
+
+		rows = append(rows, cli_utils.TableRow{
+			Columns: []string{name, strings.Join(t.DependsOn, ", "), description},
+			Color:   color,
+		})
+	}
+
+	if len(rows) == 0 {
+		cli_utils.PrintInfoMessage("The project has no targets")
+		return nil
+	}
+
+	cli_utils.PrintTable([]string{"TARGET", "DEPENDS ON", "DESCRIPTION"}, rows, []int{34, 30, 40})
+	if def != "" {
+		cli_utils.PrintColoredLine("* run by krill run without a target", cli_utils.ColorGray)
+	}
+
+	return nil
+}
+
+// validateTargets checks the names targets can be run with on the command
+// line, they have to be unique, and the default target has to exist
+func validateTargets(cfg *config.Cfg) error {
+	names := make(map[string]string)
+	for _, name := range sortedKeys(cfg.BuildTargets) {
+		names[name] = name
+	}
+
+	for _, name := range sortedKeys(cfg.BuildTargets) {
+		for _, alias := range cfg.BuildTargets[name].Aliases {
+			if other, ok := names[alias]; ok {
+				if other == alias {
+					return fmt.Errorf("alias %q of target %s is already the name of a target", alias, name)
+				}
+
+				return fmt.Errorf("alias %q of target %s is already used by target %s", alias, name, other)
+			}
+
+			names[alias] = name
+		}
+	}
+
+	if d := cfg.Project.DefaultTarget; d != "" {
+		if _, ok := names[d]; !ok {
+			return fmt.Errorf("default_target %s is not a target of the project", d)
+		}
+	}
+
+	return nil
+}
+
+// DefaultTarget is the name of the target run by krill run without a target,
+// default_target may also be an alias
+func DefaultTarget(cfg *config.Cfg) string {
+	name, _ := resolveTarget(cfg, cfg.Project.DefaultTarget)
+	return name
+}
+
+// Selection picks the targets of a krill run invocation
+type Selection struct {
+	Patterns    []string // target names, aliases, globs or "<path>:<target>"
+	Tags        []string // every target with one of these tags
+	ExcludeTags []string // drop selected targets with one of these tags
+}
+
+func (s Selection) empty() bool {
+	return len(s.Patterns) == 0 && len(s.Tags) == 0 && len(s.ExcludeTags) == 0
+}
+
+// selectTargets expands a selection into target names, in the order they were
+// selected and without duplicates. Globs and tags never select hidden targets.
+func selectTargets(cfg *config.Cfg, sel Selection) ([]string, error) {
+	var targets []string
+	add := func(name string) {
+		if !slices.Contains(targets, name) {
+			targets = append(targets, name)
+		}
+	}
+
+	for _, pattern := range sel.Patterns {
+		if name, ok := resolveTarget(cfg, pattern); ok {
+			add(name)
+			continue
+		}
+
+		// targets of other projects are resolved when the plan is built
+		if isRef(".", pattern) {
+			add(pattern)
+			continue
+		}
+
+		if !strings.ContainsAny(pattern, "*?[") {
+			return nil, fmt.Errorf("Target %s does not exist in the project", pattern)
+		}
+
+		matched := false
+		for _, name := range sortedKeys(cfg.BuildTargets) {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target pattern %q: %w", pattern, err)
+			}
+
+			if ok && !cfg.BuildTargets[name].Hidden {
+				matched = true
+				add(name)
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("no target matches %q", pattern)
+		}
+	}
+
+	// only excluding tags selects every target first
+	all := len(sel.Patterns) == 0 && len(sel.Tags) == 0
+	for _, name := range sortedKeys(cfg.BuildTargets) {
+		t := cfg.BuildTargets[name]
+		if !t.Hidden && (all || hasTag(t, sel.Tags)) {
+			add(name)
+		}
+	}
+
+	targets = slices.DeleteFunc(targets, func(name string) bool {
+		t, ok := cfg.BuildTargets[name]
+		return ok && hasTag(t, sel.ExcludeTags)
+	})
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets selected")
+	}
+
+	return targets, nil
+}
+
+// resolveTarget finds a target of the project by its name or one of its aliases
+func resolveTarget(cfg *config.Cfg, name string) (string, bool) {
+	if _, ok := cfg.BuildTargets[name]; ok {
+		return name, true
+	}
+
+	for _, target := range sortedKeys(cfg.BuildTargets) {
+		if slices.Contains(cfg.BuildTargets[target].Aliases, name) {
+			return target, true
+		}
+	}
+
+	return "", false
+}
+
+func hasTag(t config.BuildTarget, tags []string) bool {
+	return slices.ContainsFunc(tags, func(tag string) bool {
+		return slices.Contains(t.Tags, tag)
+	})
+}
@@ -0,0 +1,15 @@
+//go:build unix && !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package build
+
+import "errors"
+
+// commands never get the terminal here, they run in the background like
+// they would without a terminal
+func inForeground(fd int) bool {
+	return false
+}
+
+func takeTerminal(fd int) error {
+	return errors.New("terminal job control is not supported on this platform")
+}
@@ -0,0 +1,30 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package build
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// inForeground reports whether the terminal delivers Ctrl+C to the process
+// group of krill
+func inForeground(fd int) bool {
+	var pgid int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgid))); errno != 0 {
+		return false
+	}
+
+	return int(pgid) == syscall.Getpgrp()
+}
+
+// takeTerminal moves the process group of krill back to the foreground,
+// SIGTTOU has to be ignored
+func takeTerminal(fd int) error {
+	pgid := int32(syscall.Getpgrp())
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgid))); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/kociumba/krill/cli_utils"
+)
+
+// printTimings prints how long every target of a run took, slowest first
+func printTimings(run *RunRecord) {
+	if len(run.Targets) == 0 {
+		return
+	}
+
+	targets := slices.Clone(run.Targets)
+	slices.SortStableFunc(targets, func(a, b *TargetRecord) int {
+		return int(duration(b.Started, b.Finished) - duration(a.Started, a.Finished))
+	})
+
+	wall := duration(run.Started, run.Finished)
+	var total time.Duration
+	var rows []cli_utils.TableRow
+	for _, t := range targets {
+		d := duration(t.Started, t.Finished)
+		total += d
+
+		share := "-"
+		if wall > 0 {
+			share = fmt.Sprintf("%.1f%%", float64(d)/float64(wall)*100)
+		}
+
+		rows = append(rows, cli_utils.TableRow{
+			Columns: []string{t.Name, t.Status, formatDuration(t.Started, t.Finished), share},
+			Color:   statusColor(t.Status),
+		})
+	}
+
+	fmt.Println()
+	cli_utils.PrintTable([]string{"TARGET", "STATUS", "DURATION", "OF RUN"}, rows, []int{30, 12, 10, 7})
+	cli_utils.PrintColoredLine(fmt.Sprintf("%d targets in %s, %s of target time", len(targets), wall.Round(time.Millisecond), total.Round(time.Millisecond)), cli_utils.ColorGray)
+}
+
+func duration(start, end time.Time) time.Duration {
+	if end.IsZero() {
+		return 0
+	}
+
+	return end.Sub(start)
+}
+
+// traceEvent is a single event of the Chrome trace event format, timestamps
+// and durations are in microseconds
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   int64          `json:"ts"`
+	Dur  int64          `json:"dur,omitempty"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// writeTrace writes a run as complete events, targets running at the same time
+// are put on separate lanes so they show up like threads of a worker pool
+func writeTrace(path string, run *RunRecord) error {
+	micros := func(t time.Time) int64 {
+		return t.Sub(run.Started).Microseconds()
+	}
+
+	end := func(t time.Time) time.Time {
+		if t.IsZero() {
+			return run.Finished
+		}
+
+		return t
+	}
+
+	targets := slices.Clone(run.Targets)
+	slices.SortStableFunc(targets, func(a, b *TargetRecord) int {
+		return a.Started.Compare(b.Started)
+	})
+
+	events := []traceEvent{{
+		Name: "process_name",
+		Ph:   "M",
+		Pid:  1,
+		Args: map[string]any{"name": "krill run " + run.Target},
+	}}
+
+	var lanes []time.Time
+	for _, t := range targets {
+		lane := slices.IndexFunc(lanes, func(free time.Time) bool { return !free.After(t.Started) })
+		if lane < 0 {
+			lane = len(lanes)
+			lanes = append(lanes, time.Time{})
+			events = append(events, traceEvent{
+				Name: "thread_name",
+				Ph:   "M",
+				Pid:  1,
+				Tid:  lane + 1,
+				Args: map[string]any{"name": fmt.Sprintf("job %d", lane+1)},
+			})
+		}
+
+		lanes[lane] = end(t.Finished)
+
+		events = append(events, traceEvent{
+			Name: t.Name,
+			Cat:  "target",
+			Ph:   "X",
+			Ts:   micros(t.Started),
+			Dur:  end(t.Finished).Sub(t.Started).Microseconds(),
+			Pid:  1,
+			Tid:  lane + 1,
+			Args: map[string]any{"status": t.Status, "dir": t.Dir},
+		})
+
+		for _, c := range t.Commands {
+			events = append(events, traceEvent{
+				Name: c.Command,
+				Cat:  "command",
+				Ph:   "X",
+				Ts:   micros(c.Started),
+				Dur:  end(c.Finished).Sub(c.Started).Microseconds(),
+				Pid:  1,
+				Tid:  lane + 1,
+				Args: map[string]any{"exit_code": c.ExitCode},
+			})
+		}
+	}
+
+	b, err := json.MarshalIndent(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, b, 0644)
+}
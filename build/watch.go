@@ -0,0 +1,247 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kociumba/krill/cli_utils"
+	"github.com/kociumba/krill/config"
+	"github.com/kociumba/krill/watch"
+)
+
+const watchDebounce = 300 * time.Millisecond
+
+// watchTarget builds the targets, then rebuilds them every time a relevant
+// file changes, a change during a build cancels it and starts a new one.
+// Changes to krill.toml of any project in the plan reload the config with
+// load, if the new config is invalid the previous one is kept.
+func watchTarget(ctx context.Context, cfg *config.Cfg, load func() (*config.Cfg, error), targets []string, opts Options) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	p, err := newPlan(cfg, wd, targets)
+	if err != nil {
+		return err
+	}
+
+	w, err := watch.New(wd, p.watchSkip())
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", wd, err)
+	}
+	defer func() { w.Close() }()
+
+	for {
+		reload := false
+		relevant := p.watchFilter()
+		changed := func(rel string) bool {
+			if p.isConfig(rel) {
+				reload = true
+				return true
+			}
+
+			return relevant(rel)
+		}
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() {
+			done <- buildTarget(runCtx, cfg, targets, opts)
+		}()
+
+		finished := false
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				cancel()
+				if !finished {
+					<-done
+				}
+
+				return context.Cause(ctx)
+			case err := <-done:
+				finished = true
+				if err != nil {
+					cli_utils.PrintErrorMessage(err.Error())
+				} else {
+					cli_utils.PrintSuccessMessage(fmt.Sprintf("%s finished", p.rootLabels()))
+				}
+
+				cli_utils.PrintInfoMessage("Watching for changes...")
+			case rel := <-w.Changes():
+				if changed(rel) {
+					break wait
+				}
+			}
+		}
+
+		debounce(ctx, w, changed)
+		cancel()
+		if !finished {
+			<-done
+		}
+
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+
+		if reload {
+			if next, nextPlan, err := reloadPlan(load, wd, targets); err != nil {
+				cli_utils.PrintErrorMessage(fmt.Sprintf("%v, keeping the previous config", err))
+			} else if nw, err := watch.New(wd, nextPlan.watchSkip()); err != nil {
+				cli_utils.PrintErrorMessage(fmt.Sprintf("failed to watch %s: %v, keeping the previous config", wd, err))
+			} else {
+				w.Close()
+				cfg, p, w = next, nextPlan, nw
+				cli_utils.PrintInfoMessage("Config changed, reloaded it")
+			}
+		}
+
+		if !finished {
+			cli_utils.PrintWarningMessage("Changes detected, restarting the build")
+		} else {
+			cli_utils.PrintInfoMessage("Changes detected, rebuilding")
+		}
+	}
+}
+
+// reloadPlan loads the config again and plans the same targets with it
+func reloadPlan(load func() (*config.Cfg, error), wd string, targets []string) (*config.Cfg, *plan, error) {
+	cfg, err := load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to reload the config: %w", err)
+	}
+
+	p, err := newPlan(cfg, wd, targets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to reload the config: %w", err)
+	}
+
+	return cfg, p, nil
+}
+
+// debounce waits until no relevant change arrived for watchDebounce
+func debounce(ctx context.Context, w watch.Watcher, relevant func(string) bool) {
+	timer := time.NewTimer(watchDebounce)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			return
+		case rel := <-w.Changes():
+			if relevant(rel) {
+				timer.Reset(watchDebounce)
+			}
+		}
+	}
+}
+
+// watchSkip ignores the globally excluded directories, the output
+// directories and declared outputs of every target in the plan
+func (p *plan) watchSkip() watch.Skip {
+	outputDirs := make(map[string]struct{})
+	for _, n := range p.order {
+		if n.target.OutputDir != "" {
+			outputDirs[p.rootRel(n, n.target.OutputDir)] = struct{}{}
+		}
+	}
+
+	return func(rel string, isDir bool) bool {
+		if isDir {
+			if _, ok := config.ExcludeDirs[path.Base(rel)]; ok {
+				return true
+			}
+
+			_, ok := outputDirs[rel]
+			return ok
+		}
+
+		for _, n := range p.order {
+			if nodeRel, ok := p.nodeRel(n, rel); ok && matchesAny(n.target.Outputs, nodeRel) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// watchFilter matches changes against the declared inputs of the targets in
+// the plan, if no target declares inputs every change is relevant
+func (p *plan) watchFilter() func(rel string) bool {
+	hasInputs := false
+	for _, n := range p.order {
+		if len(n.target.Inputs) > 0 {
+			hasInputs = true
+			break
+		}
+	}
+
+	if !hasInputs {
+		return func(string) bool { return true }
+	}
+
+	return func(rel string) bool {
+		for _, n := range p.order {
+			if nodeRel, ok := p.nodeRel(n, rel); ok && matchesAny(n.target.Inputs, nodeRel) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// isConfig reports whether a path relative to the root is the config of
+// one of the projects in the plan
+func (p *plan) isConfig(rel string) bool {
+	if rel == config.ConfigPath("") {
+		return true
+	}
+
+	for _, n := range p.order {
+		if rel == p.rootRel(n, config.ConfigPath("")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rootRel converts a path relative to a node into one relative to the root
+func (p *plan) rootRel(n *node, rel string) string {
+	return filepath.ToSlash(filepath.Join(p.relDir(n), filepath.FromSlash(rel)))
+}
+
+// nodeRel converts a path relative to the root into one relative to a node
+func (p *plan) nodeRel(n *node, rel string) (string, bool) {
+	dir := filepath.ToSlash(p.relDir(n))
+	if dir == "." {
+		return rel, true
+	}
+
+	if !strings.HasPrefix(rel, dir+"/") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(rel, dir+"/"), true
+}
+
+func (p *plan) relDir(n *node) string {
+	rel, err := filepath.Rel(p.dir, n.dir)
+	if err != nil {
+		return "."
+	}
+
+	return rel
+}
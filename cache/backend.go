@@ -0,0 +1,179 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/kociumba/krill/config"
+)
+
+// Backend is a store of content addressed blobs and the action result
+// manifests referencing them
+type Backend interface {
+	GetManifest(key string) (*Manifest, bool, error)
+	PutManifest(m *Manifest) error
+	HasBlob(hash string) (bool, error)
+	OpenBlob(hash string) (io.ReadCloser, error)
+	PutBlob(hash string, r io.Reader) error
+}
+
+var _ Backend = (*Local)(nil)
+var _ Backend = (*Remote)(nil)
+
+type Options struct {
+	Dir      string
+	Remote   string
+	ReadOnly bool
+	Token    string
+}
+
+// Cache is the local cache optionally backed by a shared remote one, entries
+// found remotely are downloaded into the local cache before being restored.
+// Any remote failure disables the remote for the rest of the run and the
+// cache keeps working locally.
+type Cache struct {
+	local    *Local
+	remote   Backend
+	readOnly bool
+	down     atomic.Bool
+}
+
+func New(opts Options) (*Cache, error) {
+	local, err := Open(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cache{local: local, readOnly: opts.ReadOnly}
+	if opts.Remote != "" {
+		c.remote, err = NewRemote(opts.Remote, opts.Token)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// FromConfig opens the cache described by a project [cache] section merged
+// with the user config and environment, the bearer token for the remote is
+// taken from KRILL_CACHE_TOKEN
+func FromConfig(project config.CacheConfig) (*Cache, error) {
+	cfg, err := config.ResolveCacheConfig(project)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(Options{
+		Dir:      cfg.Dir,
+		Remote:   cfg.Remote,
+		ReadOnly: cfg.ReadOnly != nil && *cfg.ReadOnly,
+		Token:    os.Getenv("KRILL_CACHE_TOKEN"),
+	})
+}
+
+func (c *Cache) Local() *Local {
+	return c.local
+}
+
+func (c *Cache) remoteUp() bool {
+	return c.remote != nil && !c.down.Load()
+}
+
+func (c *Cache) remoteErr(err error) error {
+	c.down.Store(true)
+	return fmt.Errorf("remote cache unavailable, continuing with the local cache only: %w", err)
+}
+
+// Get looks the key up locally and then remotely, a returned error is only
+// informational, the lookup is then treated as a miss
+func (c *Cache) Get(key string) (*Manifest, bool, error) {
+	m, ok, err := c.local.GetManifest(key)
+	if err != nil || ok || !c.remoteUp() {
+		return m, ok, err
+	}
+
+	m, ok, err = c.remote.GetManifest(key)
+	if err != nil {
+		return nil, false, c.remoteErr(err)
+	}
+
+	if !ok {
+		return nil, false, nil
+	}
+
+	for _, f := range m.Files {
+		if has, _ := c.local.HasBlob(f.Hash); has {
+			continue
+		}
+
+		if err := c.download(f.Hash); err != nil {
+			return nil, false, c.remoteErr(err)
+		}
+	}
+
+	if err := c.local.PutManifest(m); err != nil {
+		return nil, false, err
+	}
+
+	return m, true, nil
+}
+
+func (c *Cache) download(hash string) error {
+	r, err := c.remote.OpenBlob(hash)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return c.local.PutBlob(hash, r)
+}
+
+// Put stores the files locally and uploads them unless the cache is read only
+func (c *Cache) Put(key, root string, files []string) error {
+	m, err := c.local.Put(key, root, files)
+	if err != nil {
+		return err
+	}
+
+	if c.readOnly || !c.remoteUp() {
+		return nil
+	}
+
+	for _, f := range m.Files {
+		has, err := c.remote.HasBlob(f.Hash)
+		if err != nil {
+			return c.remoteErr(err)
+		}
+
+		if has {
+			continue
+		}
+
+		if err := c.upload(f.Hash); err != nil {
+			return c.remoteErr(err)
+		}
+	}
+
+	if err := c.remote.PutManifest(m); err != nil {
+		return c.remoteErr(err)
+	}
+
+	return nil
+}
+
+func (c *Cache) upload(hash string) error {
+	r, err := c.local.OpenBlob(hash)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	return c.remote.PutBlob(hash, r)
+}
+
+func (c *Cache) Restore(m *Manifest, root string) error {
+	return c.local.Restore(m, root)
+}
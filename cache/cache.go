@@ -0,0 +1,310 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Entry is a single file restored by an action result
+type Entry struct {
+	Path string      `json:"path"`
+	Hash string      `json:"hash"`
+	Size int64       `json:"size"`
+	Mode fs.FileMode `json:"mode"`
+}
+
+// Manifest describes the outputs produced by a target for a given cache key,
+// the file contents themselves are stored as content addressed blobs
+type Manifest struct {
+	Key     string    `json:"key"`
+	Files   []Entry   `json:"files"`
+	Created time.Time `json:"created"`
+}
+
+// Local is a content addressed cache on the local filesystem, blobs are
+// stored under cas/ and action results under ac/
+type Local struct {
+	dir string
+}
+
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		home, herr := os.UserHomeDir()
+		if herr != nil {
+			return "", err
+		}
+
+		dir = filepath.Join(home, ".cache")
+	}
+
+	return filepath.Join(dir, "krill"), nil
+}
+
+func Open(dir string) (*Local, error) {
+	if dir == "" {
+		var err error
+		dir, err = DefaultDir()
+		if err != nil {
+			return nil, fmt.Errorf("could not determine cache directory: %w", err)
+		}
+	}
+
+	for _, sub := range []string{"cas", "ac", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+	}
+
+	return &Local{dir: dir}, nil
+}
+
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+func (l *Local) blobPath(hash string) string {
+	return filepath.Join(l.dir, "cas", hash[:2], hash)
+}
+
+func (l *Local) manifestPath(key string) string {
+	return filepath.Join(l.dir, "ac", key+".json")
+}
+
+// GetManifest returns the manifest stored for key, a missing entry is not an
+// error
+func (l *Local) GetManifest(key string) (*Manifest, bool, error) {
+	if !validHash(key) {
+		return nil, false, fmt.Errorf("invalid cache key %q", key)
+	}
+
+	b, err := os.ReadFile(l.manifestPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, false, fmt.Errorf("corrupted cache entry %s: %w", key, err)
+	}
+
+	for _, f := range m.Files {
+		if _, err := os.Stat(l.blobPath(f.Hash)); err != nil {
+			return nil, false, nil
+		}
+	}
+
+	// the manifest mtime doubles as the last access time used by Prune
+	now := time.Now()
+	os.Chtimes(l.manifestPath(key), now, now)
+
+	return &m, true, nil
+}
+
+// Put stores the given files, relative to root, under key
+func (l *Local) Put(key, root string, files []string) (*Manifest, error) {
+	m := &Manifest{Key: key, Created: time.Now().UTC()}
+
+	for _, file := range files {
+		entry, err := l.putFile(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to cache %s: %w", file, err)
+		}
+
+		entry.Path = file
+		m.Files = append(m.Files, entry)
+	}
+
+	if err := l.PutManifest(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (l *Local) PutManifest(m *Manifest) error {
+	if !validHash(m.Key) {
+		return fmt.Errorf("invalid cache key %q", m.Key)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return l.writeAtomic(l.manifestPath(m.Key), bytes.NewReader(b), nil)
+}
+
+func (l *Local) putFile(path string) (Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Entry{}, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		Hash: hex.EncodeToString(h.Sum(nil)),
+		Size: info.Size(),
+		Mode: info.Mode().Perm(),
+	}
+
+	if ok, _ := l.HasBlob(entry.Hash); ok {
+		return entry, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Entry{}, err
+	}
+
+	return entry, l.PutBlob(entry.Hash, f)
+}
+
+func (l *Local) HasBlob(hash string) (bool, error) {
+	if !validHash(hash) {
+		return false, fmt.Errorf("invalid blob hash %q", hash)
+	}
+
+	_, err := os.Stat(l.blobPath(hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (l *Local) OpenBlob(hash string) (io.ReadCloser, error) {
+	if !validHash(hash) {
+		return nil, fmt.Errorf("invalid blob hash %q", hash)
+	}
+
+	return os.Open(l.blobPath(hash))
+}
+
+// PutBlob stores the content of r under hash, the content is verified
+// against the hash before it becomes visible
+func (l *Local) PutBlob(hash string, r io.Reader) error {
+	if !validHash(hash) {
+		return fmt.Errorf("invalid blob hash %q", hash)
+	}
+
+	path := l.blobPath(hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	return l.writeAtomic(path, io.TeeReader(r, h), func() error {
+		if got := hex.EncodeToString(h.Sum(nil)); got != hash {
+			return fmt.Errorf("blob content does not match hash %s", hash)
+		}
+
+		return nil
+	})
+}
+
+// Restore writes every file of the manifest back under root
+func (l *Local) Restore(m *Manifest, root string) error {
+	for _, f := range m.Files {
+		if !filepath.IsLocal(filepath.FromSlash(f.Path)) {
+			return fmt.Errorf("refusing to restore %s outside of the project", f.Path)
+		}
+
+		if err := l.restoreFile(f, filepath.Join(root, filepath.FromSlash(f.Path))); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", f.Path, err)
+		}
+	}
+
+	return nil
+}
+
+func (l *Local) restoreFile(f Entry, dst string) error {
+	src, err := os.Open(l.blobPath(f.Hash))
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".krill-restore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), f.Mode); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+// writeAtomic writes r to a temporary file and moves it to path, unless
+// verify rejects the written content
+func (l *Local) writeAtomic(path string, r io.Reader, verify func() error) error {
+	tmp, err := os.CreateTemp(filepath.Join(l.dir, "tmp"), "write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if verify != nil {
+		if err := verify(); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func validHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
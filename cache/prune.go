@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Stats struct {
+	Entries   int
+	Blobs     int
+	BlobBytes int64
+	Oldest    time.Time
+	Newest    time.Time
+}
+
+type PruneResult struct {
+	RemovedEntries int
+	RemovedBlobs   int
+	FreedBytes     int64
+}
+
+type manifestInfo struct {
+	path     string
+	accessed time.Time
+	size     int64
+	hashes   []string
+}
+
+func (l *Local) Stats() (Stats, error) {
+	var s Stats
+
+	manifests, err := l.manifests()
+	if err != nil {
+		return s, err
+	}
+
+	s.Entries = len(manifests)
+	for _, m := range manifests {
+		if s.Oldest.IsZero() || m.accessed.Before(s.Oldest) {
+			s.Oldest = m.accessed
+		}
+
+		if m.accessed.After(s.Newest) {
+			s.Newest = m.accessed
+		}
+	}
+
+	blobs, err := l.blobs()
+	if err != nil {
+		return s, err
+	}
+
+	s.Blobs = len(blobs)
+	for _, size := range blobs {
+		s.BlobBytes += size
+	}
+
+	return s, nil
+}
+
+// Prune removes the least recently used entries until the cache takes up at
+// most maxSize bytes, blobs no longer referenced by any entry are removed
+// along the way
+func (l *Local) Prune(maxSize int64) (PruneResult, error) {
+	var res PruneResult
+
+	manifests, err := l.manifests()
+	if err != nil {
+		return res, err
+	}
+
+	blobs, err := l.blobs()
+	if err != nil {
+		return res, err
+	}
+
+	refs := make(map[string]int)
+	var total int64
+	for _, m := range manifests {
+		total += m.size
+		for _, h := range m.hashes {
+			refs[h]++
+		}
+	}
+
+	removeBlob := func(hash string) error {
+		if err := os.Remove(l.blobPath(hash)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		res.RemovedBlobs++
+		res.FreedBytes += blobs[hash]
+		total -= blobs[hash]
+		return nil
+	}
+
+	for hash, size := range blobs {
+		total += size
+		if refs[hash] == 0 {
+			if err := removeBlob(hash); err != nil {
+				return res, err
+			}
+		}
+	}
+
+	slices.SortFunc(manifests, func(a, b manifestInfo) int {
+		return a.accessed.Compare(b.accessed)
+	})
+
+	for _, m := range manifests {
+		if total <= maxSize {
+			break
+		}
+
+		if err := os.Remove(m.path); err != nil && !os.IsNotExist(err) {
+			return res, err
+		}
+
+		res.RemovedEntries++
+		res.FreedBytes += m.size
+		total -= m.size
+
+		for _, h := range m.hashes {
+			refs[h]--
+			if refs[h] == 0 {
+				if err := removeBlob(h); err != nil {
+					return res, err
+				}
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (l *Local) manifests() ([]manifestInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(l.dir, "ac"))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []manifestInfo
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(l.dir, "ac", e.Name())
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var m Manifest
+		mi := manifestInfo{path: path, accessed: info.ModTime(), size: info.Size()}
+		if err := json.Unmarshal(b, &m); err == nil {
+			for _, f := range m.Files {
+				mi.hashes = append(mi.hashes, f.Hash)
+			}
+		}
+
+		out = append(out, mi)
+	}
+
+	return out, nil
+}
+
+func (l *Local) blobs() (map[string]int64, error) {
+	blobs := make(map[string]int64)
+	err := filepath.WalkDir(filepath.Join(l.dir, "cas"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		blobs[d.Name()] = info.Size()
+		return nil
+	})
+
+	return blobs, err
+}
+
+// ParseSize parses sizes like "512", "300K", "1.5G" or "2GB" into bytes
+func ParseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(s, "B")
+
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(n * float64(mult)), nil
+}
+
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
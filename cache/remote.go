@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Remote talks to a cache server over plain HTTP:
+//
+//	GET|HEAD|PUT <base>/cas/<sha256>  content addressed blobs
+//	GET|PUT      <base>/ac/<key>      action result manifests as JSON
+//
+// a 404 is a cache miss, anything other than 2xx is an error
+type Remote struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+func NewRemote(base, token string) (*Remote, error) {
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid remote cache url %q", base)
+	}
+
+	return &Remote{
+		base:   strings.TrimSuffix(u.String(), "/"),
+		token:  token,
+		client: &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+func (r *Remote) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, r.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	return r.client.Do(req)
+}
+
+func (r *Remote) GetManifest(key string) (*Manifest, bool, error) {
+	if !validHash(key) {
+		return nil, false, fmt.Errorf("invalid cache key %q", key)
+	}
+
+	resp, err := r.do(http.MethodGet, "/ac/"+key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+
+	if err := checkStatus(resp); err != nil {
+		return nil, false, err
+	}
+
+	var m Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, false, fmt.Errorf("invalid manifest for %s: %w", key, err)
+	}
+
+	if m.Key != key {
+		return nil, false, fmt.Errorf("remote returned manifest %s for key %s", m.Key, key)
+	}
+
+	return &m, true, nil
+}
+
+func (r *Remote) PutManifest(m *Manifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	resp, err := r.do(http.MethodPut, "/ac/"+m.Key, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkStatus(resp)
+}
+
+func (r *Remote) HasBlob(hash string) (bool, error) {
+	resp, err := r.do(http.MethodHead, "/cas/"+hash, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if err := checkStatus(resp); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (r *Remote) OpenBlob(hash string) (io.ReadCloser, error) {
+	resp, err := r.do(http.MethodGet, "/cas/"+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkStatus(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+func (r *Remote) PutBlob(hash string, body io.Reader) error {
+	resp, err := r.do(http.MethodPut, "/cas/"+hash, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkStatus(resp)
+}
+
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+}
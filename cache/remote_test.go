@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func hashOf(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// testServer serves a fresh local cache over HTTP
+func testServer(t *testing.T, token string, readOnly bool) (*httptest.Server, *Local) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(NewServer(store, token, readOnly))
+	t.Cleanup(srv.Close)
+	return srv, store
+}
+
+func TestRemoteRoundTrip(t *testing.T) {
+	srv, _ := testServer(t, "secret", false)
+
+	project := t.TempDir()
+	if err := os.WriteFile(filepath.Join(project, "out.txt"), []byte("built"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	key := hashOf("key")
+	upload, err := New(Options{Dir: t.TempDir(), Remote: srv.URL, Token: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := upload.Put(key, project, []string{"out.txt"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	// a second machine with an empty local cache
+	download, err := New(Options{Dir: t.TempDir(), Remote: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok, err := download.Get(key)
+	if err != nil || !ok {
+		t.Fatalf("Get() = %v, %v, want a hit", ok, err)
+	}
+
+	restored := t.TempDir()
+	if err := download.Restore(m, restored); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(restored, "out.txt")); err != nil || string(b) != "built" {
+		t.Errorf("restored out.txt = %q, %v, want %q", b, err, "built")
+	}
+
+	// the entry is in the local cache now
+	if _, ok, _ := download.Local().GetManifest(key); !ok {
+		t.Error("Get() did not store the remote entry locally")
+	}
+}
+
+func TestRemoteMiss(t *testing.T) {
+	srv, _ := testServer(t, "", false)
+	r, err := NewRemote(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m, ok, err := r.GetManifest(hashOf("missing")); m != nil || ok || err != nil {
+		t.Errorf("GetManifest() = %v, %v, %v, want a miss without an error", m, ok, err)
+	}
+
+	if ok, err := r.HasBlob(hashOf("missing")); ok || err != nil {
+		t.Errorf("HasBlob() = %v, %v, want a miss without an error", ok, err)
+	}
+
+	if _, err := r.OpenBlob(hashOf("missing")); err == nil {
+		t.Error("OpenBlob() of a missing blob succeeded")
+	}
+}
+
+func TestRemoteRejectsUploads(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		readOnly bool
+		err      string
+	}{
+		{"read only", "secret", true, "403"},
+		{"wrong token", "wrong", false, "401"},
+		{"no token", "", false, "401"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, store := testServer(t, "secret", tt.readOnly)
+			r, err := NewRemote(srv.URL, tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hash := hashOf("blob")
+			if err := r.PutBlob(hash, strings.NewReader("blob")); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("PutBlob() error = %v, want %s", err, tt.err)
+			}
+
+			if err := r.PutManifest(&Manifest{Key: hashOf("key")}); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("PutManifest() error = %v, want %s", err, tt.err)
+			}
+
+			if has, _ := store.HasBlob(hash); has {
+				t.Error("rejected blob was stored")
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package cache
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Server exposes a local cache over the protocol spoken by Remote, it is
+// meant as a minimal stand-in for testing and small teams, not as a
+// hardened cache service
+type Server struct {
+	store    *Local
+	token    string
+	readOnly bool
+}
+
+func NewServer(store *Local, token string, readOnly bool) *Server {
+	return &Server{store: store, token: token, readOnly: readOnly}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	kind, id, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if !ok || !validHash(id) || (kind != "cas" && kind != "ac") {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if kind == "cas" {
+			s.getBlob(w, r, id)
+		} else {
+			s.getManifest(w, r, id)
+		}
+	case http.MethodPut:
+		if s.readOnly {
+			http.Error(w, "cache is read only", http.StatusForbidden)
+			return
+		}
+
+		if s.token != "" && r.Header.Get("Authorization") != "Bearer "+s.token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if kind == "cas" {
+			s.putBlob(w, r, id)
+		} else {
+			s.putManifest(w, r, id)
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) getBlob(w http.ResponseWriter, r *http.Request, hash string) {
+	f, err := s.store.OpenBlob(hash)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	io.Copy(w, f)
+}
+
+func (s *Server) getManifest(w http.ResponseWriter, r *http.Request, key string) {
+	m, ok, err := s.store.GetManifest(key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	json.NewEncoder(w).Encode(m)
+}
+
+func (s *Server) putBlob(w http.ResponseWriter, r *http.Request, hash string) {
+	if err := s.store.PutBlob(hash, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Printf("stored blob %s", hash)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) putManifest(w http.ResponseWriter, r *http.Request, key string) {
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(&m); err != nil || m.Key != key {
+		http.Error(w, "invalid manifest", http.StatusBadRequest)
+		return
+	}
+
+	for _, f := range m.Files {
+		if has, _ := s.store.HasBlob(f.Hash); !has {
+			http.Error(w, "manifest references missing blob "+f.Hash, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := s.store.PutManifest(&m); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("stored action result %s (%d files)", key, len(m.Files))
+	w.WriteHeader(http.StatusCreated)
+}
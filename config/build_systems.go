@@ -171,6 +171,22 @@ var ToolMarkers = map[Tool][]string{
 	DotNet:   {"*.sln", "*.csproj", "*.fsproj", "*.fs", "*.cs"},
 }
 
+// ExcludeDirs are directories never searched for nested projects or watched
+// for changes, mostly vcs metadata and the default output dirs of tools
+var ExcludeDirs = map[string]struct{}{
+	".git":                {},
+	".krill":              {},
+	"node_modules":        {},
+	"vendor":              {},
+	"target":              {},
+	"bin":                 {},
+	"build":               {},
+	"cmake-build-debug":   {},
+	"cmake-build-release": {},
+	"meson-build-debug":   {},
+	"meson-build-release": {},
+}
+
 func DetectTools(root string) []Tool {
 	entries, err := filepath.Glob(filepath.Join(root, "*"))
 	if err != nil {
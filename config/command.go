@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Command is a single entry of a target's commands, written either as a plain
+// string or as a table:
+//
+//	commands = [
+//	  "go generate ./...",
+//	  { run = "npm ci", dir = "web", env = { CI = "1" }, ignore_error = true },
+//	  { run = "codesign ...", when = 'os == "darwin" && ci' },
+//	]
+type Command struct {
+	Run         string            `toml:"run"`
+	Dir         string            `toml:"dir,omitempty"`
+	Env         map[string]string `toml:"env,omitempty"`
+	IgnoreError bool              `toml:"ignore_error,omitempty"`
+	Shell       string            `toml:"shell,omitempty"`
+	When        string            `toml:"when,omitempty"` // see EvalWhen
+}
+
+func (c *Command) UnmarshalTOML(data any) error {
+	if s, ok := data.(string); ok {
+		*c = Command{Run: s}
+		return nil
+	}
+
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected a string or a table for a command, got %T", data)
+	}
+
+	*c = Command{}
+
+	for key, value := range m {
+		switch key {
+		case "run", "dir", "shell", "when":
+			s, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("command field %q must be a string, got %T", key, value)
+			}
+
+			switch key {
+			case "run":
+				c.Run = s
+			case "dir":
+				c.Dir = s
+			case "shell":
+				c.Shell = s
+			case "when":
+				if err := ValidateWhen(s); err != nil {
+					return err
+				}
+
+				c.When = s
+			}
+		case "ignore_error":
+			b, ok := value.(bool)
+			if !ok {
+				return fmt.Errorf("command field %q must be a boolean, got %T", key, value)
+			}
+
+			c.IgnoreError = b
+		case "env":
+			env, ok := value.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("command field %q must be a table, got %T", key, value)
+			}
+
+			c.Env = make(map[string]string, len(env))
+			for k, v := range env {
+				s, ok := v.(string)
+				if !ok {
+					return fmt.Errorf("env variable %q must be a string, got %T", k, v)
+				}
+
+				c.Env[k] = s
+			}
+		default:
+			return fmt.Errorf("unknown command field %q", key)
+		}
+	}
+
+	if c.Run == "" {
+		return fmt.Errorf("command table is missing the 'run' field")
+	}
+
+	return nil
+}
+
+// MarshalTOML keeps plain commands as strings, so configs written by krill
+// look the same as before structured commands existed
+func (c Command) MarshalTOML() ([]byte, error) {
+	if c.IsPlain() {
+		return tomlValue(c.Run)
+	}
+
+	var fields []string
+	add := func(key string, value any) error {
+		b, err := tomlValue(value)
+		if err != nil {
+			return err
+		}
+
+		fields = append(fields, fmt.Sprintf("%s = %s", key, b))
+		return nil
+	}
+
+	if err := add("run", c.Run); err != nil {
+		return nil, err
+	}
+
+	if c.Dir != "" {
+		if err := add("dir", c.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.Env) > 0 {
+		keys := make([]string, 0, len(c.Env))
+		for k := range c.Env {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+
+		var env []string
+		for _, k := range keys {
+			kb, err := tomlValue(k)
+			if err != nil {
+				return nil, err
+			}
+
+			vb, err := tomlValue(c.Env[k])
+			if err != nil {
+				return nil, err
+			}
+
+			env = append(env, fmt.Sprintf("%s = %s", kb, vb))
+		}
+
+		fields = append(fields, fmt.Sprintf("env = { %s }", strings.Join(env, ", ")))
+	}
+
+	if c.IgnoreError {
+		fields = append(fields, "ignore_error = true")
+	}
+
+	if c.Shell != "" {
+		if err := add("shell", c.Shell); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.When != "" {
+		if err := add("when", c.When); err != nil {
+			return nil, err
+		}
+	}
+
+	return []byte("{ " + strings.Join(fields, ", ") + " }"), nil
+}
+
+func (c Command) IsPlain() bool {
+	return c.Dir == "" && len(c.Env) == 0 && !c.IgnoreError && c.Shell == "" && c.When == ""
+}
+
+func (c Command) String() string {
+	return c.Run
+}
+
+// tomlValue encodes a single value using the toml encoder, so quoting and
+// escaping follow the spec
+func tomlValue(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]any{"v": v}); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSpace(bytes.TrimPrefix(buf.Bytes(), []byte("v = "))), nil
+}
+
+// Commands wraps plain command strings
+func Commands(cmds ...string) []Command {
+	out := make([]Command, len(cmds))
+	for i, cmd := range cmds {
+		out[i] = Command{Run: cmd}
+	}
+
+	return out
+}
+
+// ShellArgs returns the arguments a shell needs to run a single command
+// string passed as the last argument
+func ShellArgs(shell string) []string {
+	switch shellName(shell) {
+	case "powershell", "pwsh":
+		return []string{"-NoProfile", "-NoLogo", "-Command"}
+	case "cmd":
+		return []string{"/c"}
+	default:
+		return []string{"-c"}
+	}
+}
+
+var plainArg = regexp.MustCompile(`^[A-Za-z0-9_./:=+-]+$`)
+
+// QuoteArg quotes a single argument for the shell, so it reaches the command
+// as one argument, unchanged. Without a configured shell the one krill would
+// detect on this platform is assumed.
+func QuoteArg(shell, arg string) string {
+	if plainArg.MatchString(arg) {
+		return arg
+	}
+
+	name := shellName(shell)
+	if name == "" && runtime.GOOS == "windows" {
+		name = "powershell"
+	}
+
+	switch name {
+	case "powershell", "pwsh":
+		return "'" + strings.ReplaceAll(arg, "'", "''") + "'"
+	case "cmd":
+		return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+	case "fish":
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(arg) + "'"
+	default:
+		return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+}
+
+// shellName is the lower case name of a shell executable, without its
+// directory and extension
+func shellName(shell string) string {
+	if shell == "" {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSuffix(filepath.Base(shell), filepath.Ext(shell)))
+}
@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"maps"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"reflect"
 	"slices"
 
@@ -20,6 +22,8 @@ type Cfg struct {
 	Env          map[string]Environment   `toml:"env,omitempty"`
 	BuildTargets map[string]BuildTarget   `toml:"targets,omitempty"`
 	Nested       map[string]NestedProject `toml:"nested,omitempty"`
+	Cache        CacheConfig              `toml:"cache,omitempty"`
+	Logs         LogsConfig               `toml:"logs,omitempty"`
 }
 
 type Project struct {
@@ -28,21 +32,95 @@ type Project struct {
 	Languages  []Language `toml:"languages,omitempty"`
 	Tools      []Tool     `toml:"tools,omitempty"`
 	Version    string     `toml:"version,omitempty"`
+
+	// target run by a plain krill run
+	DefaultTarget string `toml:"default_target,omitempty"`
 }
 
 type Environment struct {
-	Path string   `toml:"path,omitempty"`
-	Args []string `toml:"args,omitempty"`
+	Path        string            `toml:"path,omitempty"`
+	Args        []string          `toml:"args,omitempty"`
+	Env         map[string]string `toml:"env,omitempty"`
+	EnvFiles    []string          `toml:"env_files,omitempty"`
+	PathPrepend []string          `toml:"path_prepend,omitempty"`
 }
 
 type BuildTarget struct {
-	Commands  []string `toml:"commands,omitempty"`
-	OutputDir string   `toml:"output_dir,omitempty"`
-	DependsOn []string `toml:"depends_on,omitempty"`
+	Description string   `toml:"description,omitempty"`
+	Aliases     []string `toml:"aliases,omitempty"` // other names of the target on the command line
+	Hidden      bool     `toml:"hidden,omitempty"`  // left out of help and krill targets
+	Tags        []string `toml:"tags,omitempty"`    // select targets with krill run --tag
+	Extends     string   `toml:"extends,omitempty"` // inherit from another target, see ResolveExtends
+
+	Commands  []Command `toml:"commands,omitempty"`
+	Pre       []Command `toml:"pre,omitempty"`        // before commands, after depends_on
+	Post      []Command `toml:"post,omitempty"`       // after commands succeeded
+	OnFailure []Command `toml:"on_failure,omitempty"` // after pre, commands or post failed
+	Finally   []Command `toml:"finally,omitempty"`    // always, once the target started
+	Clean     []Command `toml:"clean,omitempty"`      // run by krill clean
+	Artifacts []string  `toml:"artifacts,omitempty"`  // files packaged by krill package
+	Dir       string    `toml:"dir,omitempty"`
+	OutputDir string    `toml:"output_dir,omitempty"`
+	DependsOn []string  `toml:"depends_on,omitempty"`
+	Inputs    []string  `toml:"inputs,omitempty"`
+	Outputs   []string  `toml:"outputs,omitempty"`
+	Cache     *bool     `toml:"cache,omitempty"`
+	Quiet     *bool     `toml:"quiet,omitempty"`
+	Platforms []string  `toml:"platforms,omitempty"` // GOOS values, all platforms if empty
+	Arch      []string  `toml:"arch,omitempty"`      // GOARCH values, all architectures if empty
+
+	Params map[string]Param `toml:"params,omitempty"`
+	Matrix Matrix           `toml:"matrix,omitempty"` // expanded into one target per combination
+
+	// set on the target left in place of a matrix, depending on the target of
+	// every combination, never read from the config
+	MatrixAggregate bool `toml:"-"`
+
+	// added to the inherited lists of a target using extends
+	CommandsAppend  []Command `toml:"commands_append,omitempty"`
+	DependsOnAppend []string  `toml:"depends_on_append,omitempty"`
+	InputsAppend    []string  `toml:"inputs_append,omitempty"`
+	OutputsAppend   []string  `toml:"outputs_append,omitempty"`
+
+	Env         map[string]string `toml:"env,omitempty"`
+	EnvFiles    []string          `toml:"env_files,omitempty"`
+	PathPrepend []string          `toml:"path_prepend,omitempty"`
+}
+
+// Hooks lists the command lists of a target by their stage, in the order
+// they run
+func (t BuildTarget) Hooks() []Hook {
+	return []Hook{
+		{"pre", t.Pre},
+		{"", t.Commands},
+		{"post", t.Post},
+		{"on_failure", t.OnFailure},
+		{"finally", t.Finally},
+	}
+}
+
+func (t BuildTarget) HasCommands() bool {
+	return len(t.Commands)+len(t.Pre)+len(t.Post)+len(t.OnFailure)+len(t.Finally) > 0
+}
+
+type Hook struct {
+	Stage    string // empty for the commands of the target
+	Commands []Command
+}
+
+type CacheConfig struct {
+	Dir      string `toml:"dir,omitempty"`
+	Remote   string `toml:"remote,omitempty"`
+	ReadOnly *bool  `toml:"read_only,omitempty"`
+}
+
+type LogsConfig struct {
+	Keep int `toml:"keep,omitempty"` // number of runs kept in .krill/logs, 20 by default
 }
 
 type NestedProject struct {
-	Mappings map[string]string `toml:"mappings,omitempty"`
+	Mappings  map[string]string `toml:"mappings,omitempty"`
+	DependsOn []string          `toml:"depends_on,omitempty"` // other nested projects built first
 }
 
 func EqualTools(a, b []Tool) bool {
@@ -94,7 +172,9 @@ func EqualEnv(a, b Environment) bool {
 		}
 	}
 
-	return true
+	return maps.Equal(a.Env, b.Env) &&
+		slices.Equal(a.EnvFiles, b.EnvFiles) &&
+		slices.Equal(a.PathPrepend, b.PathPrepend)
 }
 
 func EqualNested(a, b map[string]NestedProject) bool {
@@ -108,7 +188,7 @@ func EqualNested(a, b map[string]NestedProject) bool {
 			return false
 		}
 
-		if len(va.Mappings) != len(vb.Mappings) {
+		if len(va.Mappings) != len(vb.Mappings) || !slices.Equal(va.DependsOn, vb.DependsOn) {
 			return false
 		}
 
@@ -136,6 +216,10 @@ func (p *Project) UnmarshalTOML(data any) error {
 		p.Version = version
 	}
 
+	if target, ok := m["default_target"].(string); ok {
+		p.DefaultTarget = target
+	}
+
 	if langs, ok := m["languages"].([]interface{}); ok {
 		p.Languages = make([]Language, len(langs))
 		for i, lang := range langs {
@@ -174,11 +258,18 @@ func (p *Project) UnmarshalTOML(data any) error {
 }
 
 func GetConfig() (Cfg, error) {
-	if _, err := os.Stat(cfg_file); os.IsNotExist(err) || err != nil {
+	return GetConfigFromDir("")
+}
+
+// GetConfigFromDir loads the config of the project in dir, relative paths are
+// resolved against the working directory
+func GetConfigFromDir(dir string) (Cfg, error) {
+	path := ConfigPath(dir)
+	if _, err := os.Stat(path); os.IsNotExist(err) || err != nil {
 		return Cfg{}, fmt.Errorf("error opening or finding config file")
 	}
 
-	b, err := os.ReadFile(cfg_file)
+	b, err := os.ReadFile(path)
 	if err != nil {
 		return Cfg{}, fmt.Errorf("error reading config file")
 	}
@@ -186,17 +277,14 @@ func GetConfig() (Cfg, error) {
 	cfg := Cfg{}
 	err = toml.Unmarshal(b, &cfg)
 	if err != nil {
-		return Cfg{}, fmt.Errorf("error unmarshaling config data")
+		return Cfg{}, fmt.Errorf("error unmarshaling config data: %w", err)
 	}
 
 	return cfg, nil
 }
 
-func GetConfigFromDir(dir string) (Cfg, error) {
-	oldWd, _ := os.Getwd()
-	defer os.Chdir(oldWd)
-	os.Chdir(dir)
-	return GetConfig()
+func ConfigPath(dir string) string {
+	return filepath.Join(dir, cfg_file)
 }
 
 func SaveConfig(cfg Cfg) error {
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ParseDotenv parses KEY=VALUE lines in the usual .env format: blank lines
+// and # comments are ignored, an optional "export " prefix is allowed, single
+// quoted values are taken literally, double quoted values support \n, \t, \"
+// and \\ escapes, and ${VAR} or $VAR references in unquoted and double
+// quoted values are expanded using earlier keys of the same file and then
+// lookup
+func ParseDotenv(r io.Reader, lookup func(string) (string, bool)) (map[string]string, error) {
+	vars := make(map[string]string)
+	expand := func(s string) string {
+		return os.Expand(s, func(key string) string {
+			if v, ok := vars[key]; ok {
+				return v
+			}
+
+			if lookup != nil {
+				if v, ok := lookup(key); ok {
+					return v
+				}
+			}
+
+			return ""
+		})
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+
+		value = strings.TrimSpace(value)
+		switch {
+		case strings.HasPrefix(value, "'"):
+			end := strings.Index(value[1:], "'")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quoted value", lineNo)
+			}
+
+			vars[key] = value[1 : end+1]
+		case strings.HasPrefix(value, `"`):
+			unquoted, err := unquoteDotenv(value[1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+
+			vars[key] = expand(unquoted)
+		default:
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+
+			vars[key] = expand(value)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return vars, nil
+}
+
+func unquoteDotenv(s string) (string, error) {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			return sb.String(), nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			default:
+				sb.WriteByte(s[i])
+			}
+		default:
+			sb.WriteByte(c)
+		}
+	}
+
+	return "", fmt.Errorf("unterminated double quoted value")
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	lookup := func(key string) (string, bool) {
+		v, ok := map[string]string{"HOME": "/home/krill", "EMPTY": ""}[key]
+		return v, ok
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "plain values",
+			input: "A=1\nB = two \n",
+			want:  map[string]string{"A": "1", "B": "two"},
+		},
+		{
+			name:  "comments, blank lines and export",
+			input: "# comment\n\nexport A=1\n  # indented comment\nB=2 # trailing comment\nC=a#b\n",
+			want:  map[string]string{"A": "1", "B": "2", "C": "a#b"},
+		},
+		{
+			name:  "empty value",
+			input: "A=\nB=''\nC=\"\"\n",
+			want:  map[string]string{"A": "", "B": "", "C": ""},
+		},
+		{
+			name:  "single quotes are literal",
+			input: `A='$HOME \n # not a comment'`,
+			want:  map[string]string{"A": `$HOME \n # not a comment`},
+		},
+		{
+			name:  "double quotes support escapes",
+			input: `A="line\nnext\ttab \"quoted\" back\\slash"`,
+			want:  map[string]string{"A": "line\nnext\ttab \"quoted\" back\\slash"},
+		},
+		{
+			name:  "double quotes keep #",
+			input: `A="a # b"`,
+			want:  map[string]string{"A": "a # b"},
+		},
+		{
+			name:  "references to earlier keys win over lookup",
+			input: "HOME=/srv\nA=$HOME/bin\nB=\"${HOME}/lib\"\n",
+			want:  map[string]string{"HOME": "/srv", "A": "/srv/bin", "B": "/srv/lib"},
+		},
+		{
+			name:  "references to lookup and missing variables",
+			input: "A=${HOME}/.cache\nB=${MISSING}x\nC=${EMPTY}y\n",
+			want:  map[string]string{"A": "/home/krill/.cache", "B": "x", "C": "y"},
+		},
+		{
+			name:  "later keys override earlier ones",
+			input: "A=1\nA=2\n",
+			want:  map[string]string{"A": "2"},
+		},
+		{
+			name:  "value containing =",
+			input: "A=b=c\n",
+			want:  map[string]string{"A": "b=c"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDotenv(strings.NewReader(tt.input), lookup)
+			if err != nil {
+				t.Fatalf("ParseDotenv() error = %v", err)
+			}
+
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("ParseDotenv() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"missing =", "A=1\nB\n", "line 2: expected KEY=VALUE"},
+		{"empty key", "=1", "line 1: expected KEY=VALUE"},
+		{"space in key", "A B=1", "line 1: expected KEY=VALUE"},
+		{"unterminated single quote", "A='x", "line 1: unterminated single quoted value"},
+		{"unterminated double quote", `A="x\"`, "line 1: unterminated double quoted value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDotenv(strings.NewReader(tt.input), nil)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseDotenv() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ResolveExtends merges every target with its base target, named by extends.
+// Fields set on a target override the inherited ones, env and params are
+// merged key by key, and the *_append fields are added to the end of the
+// inherited lists. description, aliases, hidden and tags are never inherited.
+// The config itself is not modified, extends and the *_append fields are
+// empty in the returned targets.
+func ResolveExtends(cfg Cfg) (Cfg, error) {
+	resolved := make(map[string]BuildTarget, len(cfg.BuildTargets))
+
+	var resolve func(name string, chain []string) (BuildTarget, error)
+	resolve = func(name string, chain []string) (BuildTarget, error) {
+		if t, ok := resolved[name]; ok {
+			return t, nil
+		}
+
+		if slices.Contains(chain, name) {
+			return BuildTarget{}, fmt.Errorf("cycle detected in extends: %s", strings.Join(append(chain, name), " -> "))
+		}
+
+		t := cfg.BuildTargets[name]
+		if t.Extends != "" {
+			if _, ok := cfg.BuildTargets[t.Extends]; !ok {
+				return BuildTarget{}, fmt.Errorf("target %s extends %s, which does not exist", name, t.Extends)
+			}
+
+			base, err := resolve(t.Extends, append(chain, name))
+			if err != nil {
+				return BuildTarget{}, err
+			}
+
+			t = inherit(base, t)
+		}
+
+		t.Commands = append(slices.Clone(t.Commands), t.CommandsAppend...)
+		t.DependsOn = append(slices.Clone(t.DependsOn), t.DependsOnAppend...)
+		t.Inputs = append(slices.Clone(t.Inputs), t.InputsAppend...)
+		t.Outputs = append(slices.Clone(t.Outputs), t.OutputsAppend...)
+		t.Extends = ""
+		t.CommandsAppend, t.DependsOnAppend, t.InputsAppend, t.OutputsAppend = nil, nil, nil, nil
+
+		resolved[name] = t
+		return t, nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(cfg.BuildTargets)) {
+		if _, err := resolve(name, nil); err != nil {
+			return Cfg{}, err
+		}
+	}
+
+	if cfg.BuildTargets != nil {
+		cfg.BuildTargets = resolved
+	}
+
+	return cfg, nil
+}
+
+// inherit fills everything t does not set from base
+func inherit(base, t BuildTarget) BuildTarget {
+	orCommands := func(v, inherited []Command) []Command {
+		if len(v) > 0 {
+			return v
+		}
+
+		return inherited
+	}
+
+	orStrings := func(v, inherited []string) []string {
+		if len(v) > 0 {
+			return v
+		}
+
+		return inherited
+	}
+
+	t.Commands = orCommands(t.Commands, base.Commands)
+	t.Pre = orCommands(t.Pre, base.Pre)
+	t.Post = orCommands(t.Post, base.Post)
+	t.OnFailure = orCommands(t.OnFailure, base.OnFailure)
+	t.Finally = orCommands(t.Finally, base.Finally)
+	t.Clean = orCommands(t.Clean, base.Clean)
+
+	t.Artifacts = orStrings(t.Artifacts, base.Artifacts)
+	t.DependsOn = orStrings(t.DependsOn, base.DependsOn)
+	t.Inputs = orStrings(t.Inputs, base.Inputs)
+	t.Outputs = orStrings(t.Outputs, base.Outputs)
+	t.Platforms = orStrings(t.Platforms, base.Platforms)
+	t.Arch = orStrings(t.Arch, base.Arch)
+	t.EnvFiles = orStrings(t.EnvFiles, base.EnvFiles)
+	t.PathPrepend = orStrings(t.PathPrepend, base.PathPrepend)
+
+	if t.Dir == "" {
+		t.Dir = base.Dir
+	}
+
+	if t.OutputDir == "" {
+		t.OutputDir = base.OutputDir
+	}
+
+	if t.Cache == nil {
+		t.Cache = base.Cache
+	}
+
+	if t.Quiet == nil {
+		t.Quiet = base.Quiet
+	}
+
+	if t.Matrix.IsEmpty() {
+		t.Matrix = base.Matrix
+	}
+
+	if len(base.Env) > 0 {
+		env := maps.Clone(base.Env)
+		maps.Copy(env, t.Env)
+		t.Env = env
+	}
+
+	if len(base.Params) > 0 {
+		params := maps.Clone(base.Params)
+		maps.Copy(params, t.Params)
+		t.Params = params
+	}
+
+	return t
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveExtends(t *testing.T) {
+	yes, no := true, false
+
+	tests := []struct {
+		name    string
+		targets map[string]BuildTarget
+		want    map[string]BuildTarget
+		err     string
+	}{
+		{
+			name: "fields of the target win",
+			targets: map[string]BuildTarget{
+				"base":  {Commands: []Command{{Run: "go build"}}, Dir: "src", OutputDir: "bin", Cache: &yes},
+				"child": {Extends: "base", OutputDir: "out"},
+			},
+			want: map[string]BuildTarget{
+				"base":  {Commands: []Command{{Run: "go build"}}, Dir: "src", OutputDir: "bin", Cache: &yes},
+				"child": {Commands: []Command{{Run: "go build"}}, Dir: "src", OutputDir: "out", Cache: &yes},
+			},
+		},
+		{
+			name: "env and params are merged",
+			targets: map[string]BuildTarget{
+				"base": {
+					Env:    map[string]string{"MODE": "debug", "CGO_ENABLED": "0"},
+					Params: map[string]Param{"mode": {Default: "debug"}, "verbose": {Type: ParamBool}},
+				},
+				"child": {
+					Extends: "base",
+					Env:     map[string]string{"MODE": "release"},
+					Params:  map[string]Param{"mode": {Default: "release"}},
+				},
+			},
+			want: map[string]BuildTarget{
+				"base": {
+					Env:    map[string]string{"MODE": "debug", "CGO_ENABLED": "0"},
+					Params: map[string]Param{"mode": {Default: "debug"}, "verbose": {Type: ParamBool}},
+				},
+				"child": {
+					Env:    map[string]string{"MODE": "release", "CGO_ENABLED": "0"},
+					Params: map[string]Param{"mode": {Default: "release"}, "verbose": {Type: ParamBool}},
+				},
+			},
+		},
+		{
+			name: "appends go after the inherited lists",
+			targets: map[string]BuildTarget{
+				"base":  {Commands: []Command{{Run: "a"}}, DependsOn: []string{"gen"}, CommandsAppend: []Command{{Run: "b"}}},
+				"child": {Extends: "base", CommandsAppend: []Command{{Run: "c"}}, DependsOnAppend: []string{"lint"}},
+				"gen":   {},
+				"lint":  {},
+			},
+			want: map[string]BuildTarget{
+				"base":  {Commands: []Command{{Run: "a"}, {Run: "b"}}, DependsOn: []string{"gen"}},
+				"child": {Commands: []Command{{Run: "a"}, {Run: "b"}, {Run: "c"}}, DependsOn: []string{"gen", "lint"}},
+				"gen":   {},
+				"lint":  {},
+			},
+		},
+		{
+			name: "chains of bases",
+			targets: map[string]BuildTarget{
+				"a": {Dir: "a", Quiet: &yes},
+				"b": {Extends: "a", OutputDir: "b"},
+				"c": {Extends: "b"},
+			},
+			want: map[string]BuildTarget{
+				"a": {Dir: "a", Quiet: &yes},
+				"b": {Dir: "a", OutputDir: "b", Quiet: &yes},
+				"c": {Dir: "a", OutputDir: "b", Quiet: &yes},
+			},
+		},
+		{
+			name: "explicit false wins over the base",
+			targets: map[string]BuildTarget{
+				"base":  {Quiet: &yes, Cache: &no},
+				"child": {Extends: "base", Quiet: &no, Cache: &yes},
+			},
+			want: map[string]BuildTarget{
+				"base":  {Quiet: &yes, Cache: &no},
+				"child": {Quiet: &no, Cache: &yes},
+			},
+		},
+		{
+			name: "description, aliases, hidden and tags are not inherited",
+			targets: map[string]BuildTarget{
+				"base":  {Description: "base", Aliases: []string{"b"}, Hidden: true, Tags: []string{"ci"}},
+				"child": {Extends: "base"},
+			},
+			want: map[string]BuildTarget{
+				"base":  {Description: "base", Aliases: []string{"b"}, Hidden: true, Tags: []string{"ci"}},
+				"child": {},
+			},
+		},
+		{
+			name: "unknown base",
+			targets: map[string]BuildTarget{
+				"child": {Extends: "base"},
+			},
+			err: "target child extends base, which does not exist",
+		},
+		{
+			name: "cycle",
+			targets: map[string]BuildTarget{
+				"a": {Extends: "b"},
+				"b": {Extends: "a"},
+			},
+			err: "cycle detected in extends: a -> b -> a",
+		},
+		{
+			name: "target extending itself",
+			targets: map[string]BuildTarget{
+				"a": {Extends: "a"},
+			},
+			err: "cycle detected in extends: a -> a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := ResolveExtends(Cfg{BuildTargets: tt.targets})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ResolveExtends() error = %v, want %q", err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ResolveExtends() error = %v", err)
+			}
+
+			for name, want := range tt.want {
+				if got := cfg.BuildTargets[name]; !reflect.DeepEqual(got, want) {
+					t.Errorf("target %s = %+v, want %+v", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveExtendsKeepsConfig(t *testing.T) {
+	cfg := Cfg{BuildTargets: map[string]BuildTarget{
+		"base":  {Env: map[string]string{"MODE": "debug"}},
+		"child": {Extends: "base", Env: map[string]string{"MODE": "release"}},
+	}}
+
+	if _, err := ResolveExtends(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.BuildTargets["child"].Extends != "base" || cfg.BuildTargets["base"].Env["MODE"] != "debug" {
+		t.Errorf("ResolveExtends modified the config: %+v", cfg.BuildTargets)
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Matrix turns a target into one target for every combination of its axes:
+//
+//	[targets.build.matrix]
+//	arch = ["x86_64", "aarch64"]
+//	mode = ["debug", "release"]
+//	exclude = [{ arch = "aarch64", mode = "debug" }]
+type Matrix struct {
+	Axes    map[string][]string
+	Exclude []map[string]string // combinations left out, may name only some axes
+}
+
+func (m *Matrix) UnmarshalTOML(data any) error {
+	table, ok := data.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected a table for a matrix, got %T", data)
+	}
+
+	*m = Matrix{Axes: make(map[string][]string)}
+
+	for key, value := range table {
+		list, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("matrix field %q must be an array, got %T", key, value)
+		}
+
+		if key == "exclude" {
+			for i, entry := range list {
+				combo, ok := entry.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("matrix exclude at index %d must be a table, got %T", i, entry)
+				}
+
+				ex := make(map[string]string, len(combo))
+				for axis, v := range combo {
+					s, ok := v.(string)
+					if !ok {
+						return fmt.Errorf("matrix exclude value of %q must be a string, got %T", axis, v)
+					}
+
+					ex[axis] = s
+				}
+
+				m.Exclude = append(m.Exclude, ex)
+			}
+
+			continue
+		}
+
+		values := make([]string, len(list))
+		for i, v := range list {
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("matrix value at index %d of %q must be a string, got %T", i, key, v)
+			}
+
+			values[i] = s
+		}
+
+		m.Axes[key] = values
+	}
+
+	return m.Validate()
+}
+
+func (m Matrix) MarshalTOML() ([]byte, error) {
+	var fields []string
+	for _, axis := range m.AxisNames() {
+		b, err := tomlValue(m.Axes[axis])
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields, fmt.Sprintf("%s = %s", axis, b))
+	}
+
+	if len(m.Exclude) > 0 {
+		var excludes []string
+		for _, ex := range m.Exclude {
+			var values []string
+			for _, axis := range slices.Sorted(maps.Keys(ex)) {
+				b, err := tomlValue(ex[axis])
+				if err != nil {
+					return nil, err
+				}
+
+				values = append(values, fmt.Sprintf("%s = %s", axis, b))
+			}
+
+			excludes = append(excludes, "{ "+strings.Join(values, ", ")+" }")
+		}
+
+		fields = append(fields, fmt.Sprintf("exclude = [%s]", strings.Join(excludes, ", ")))
+	}
+
+	return []byte("{ " + strings.Join(fields, ", ") + " }"), nil
+}
+
+func (m Matrix) Validate() error {
+	for _, axis := range m.AxisNames() {
+		if !isIdentifier(axis) {
+			return fmt.Errorf("invalid matrix axis %q, must be a valid identifier", axis)
+		}
+
+		if len(m.Axes[axis]) == 0 {
+			return fmt.Errorf("matrix axis %q has no values", axis)
+		}
+	}
+
+	for _, ex := range m.Exclude {
+		for axis := range ex {
+			if _, ok := m.Axes[axis]; !ok {
+				return fmt.Errorf("matrix exclude uses %q, which is not an axis of the matrix", axis)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m Matrix) IsEmpty() bool {
+	return len(m.Axes) == 0
+}
+
+// AxisNames are sorted, the order of a toml table is not kept
+func (m Matrix) AxisNames() []string {
+	return slices.Sorted(maps.Keys(m.Axes))
+}
+
+// Combinations lists every combination of the axes that is not excluded, the
+// values of the first axis change the slowest
+func (m Matrix) Combinations() []map[string]string {
+	if m.IsEmpty() {
+		return nil
+	}
+
+	combos := []map[string]string{{}}
+	for _, axis := range m.AxisNames() {
+		var next []map[string]string
+		for _, combo := range combos {
+			for _, value := range m.Axes[axis] {
+				c := maps.Clone(combo)
+				c[axis] = value
+				next = append(next, c)
+			}
+		}
+
+		combos = next
+	}
+
+	return slices.DeleteFunc(combos, m.excluded)
+}
+
+func (m Matrix) excluded(combo map[string]string) bool {
+	return slices.ContainsFunc(m.Exclude, func(ex map[string]string) bool {
+		for axis, value := range ex {
+			if combo[axis] != value {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// MatrixTargetName names the target of a single combination, e.g.
+// "build[arch=aarch64,mode=release]"
+func MatrixTargetName(name string, combo map[string]string) string {
+	var parts []string
+	for _, axis := range slices.Sorted(maps.Keys(combo)) {
+		parts = append(parts, axis+"="+combo[axis])
+	}
+
+	return name + "[" + strings.Join(parts, ",") + "]"
+}
+
+// isIdentifier reports whether s can be used as a field in templates, e.g.
+// {{ .matrix.arch }}
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for i, c := range s {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+
+	return true
+}
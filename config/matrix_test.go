@@ -0,0 +1,90 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatrixCombinations(t *testing.T) {
+	tests := []struct {
+		name   string
+		matrix Matrix
+		want   []map[string]string
+	}{
+		{
+			name:   "empty",
+			matrix: Matrix{},
+			want:   nil,
+		},
+		{
+			name:   "single axis",
+			matrix: Matrix{Axes: map[string][]string{"mode": {"debug", "release"}}},
+			want:   []map[string]string{{"mode": "debug"}, {"mode": "release"}},
+		},
+		{
+			name: "first axis by name changes the slowest",
+			matrix: Matrix{Axes: map[string][]string{
+				"mode": {"debug", "release"},
+				"arch": {"x86_64", "aarch64"},
+			}},
+			want: []map[string]string{
+				{"arch": "x86_64", "mode": "debug"},
+				{"arch": "x86_64", "mode": "release"},
+				{"arch": "aarch64", "mode": "debug"},
+				{"arch": "aarch64", "mode": "release"},
+			},
+		},
+		{
+			name: "exclude a full combination",
+			matrix: Matrix{
+				Axes: map[string][]string{
+					"arch": {"x86_64", "aarch64"},
+					"mode": {"debug", "release"},
+				},
+				Exclude: []map[string]string{{"arch": "aarch64", "mode": "debug"}},
+			},
+			want: []map[string]string{
+				{"arch": "x86_64", "mode": "debug"},
+				{"arch": "x86_64", "mode": "release"},
+				{"arch": "aarch64", "mode": "release"},
+			},
+		},
+		{
+			name: "exclude naming only some axes",
+			matrix: Matrix{
+				Axes: map[string][]string{
+					"arch": {"x86_64", "aarch64"},
+					"mode": {"debug", "release"},
+				},
+				Exclude: []map[string]string{{"mode": "debug"}},
+			},
+			want: []map[string]string{
+				{"arch": "x86_64", "mode": "release"},
+				{"arch": "aarch64", "mode": "release"},
+			},
+		},
+		{
+			name: "everything excluded",
+			matrix: Matrix{
+				Axes:    map[string][]string{"mode": {"debug"}},
+				Exclude: []map[string]string{{"mode": "debug"}},
+			},
+			want: []map[string]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.matrix.Combinations(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Combinations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatrixTargetName(t *testing.T) {
+	got := MatrixTargetName("build", map[string]string{"mode": "release", "arch": "aarch64"})
+	if want := "build[arch=aarch64,mode=release]"; got != want {
+		t.Errorf("MatrixTargetName() = %q, want %q", got, want)
+	}
+}
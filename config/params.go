@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	ParamString = "string"
+	ParamBool   = "bool"
+	ParamInt    = "int"
+	ParamEnum   = "enum"
+)
+
+// Param is a typed target parameter, exposed as a flag of the target's run
+// subcommand and as {{ .params.<name> }} in the config:
+//
+//	[targets.test.params]
+//	verbose = { type = "bool", description = "Print every test" }
+//	level = { type = "enum", values = ["debug", "info"], default = "info" }
+type Param struct {
+	Type        string   `toml:"type,omitempty"` // string by default
+	Default     any      `toml:"default,omitempty"`
+	Description string   `toml:"description,omitempty"`
+	Values      []string `toml:"values,omitempty"` // allowed values of an enum
+}
+
+func (p Param) Kind() string {
+	if p.Type == "" {
+		return ParamString
+	}
+
+	return p.Type
+}
+
+// Validate checks the type of the parameter and of its default value
+func (p Param) Validate(name string) error {
+	switch p.Kind() {
+	case ParamString, ParamBool, ParamInt:
+		if len(p.Values) > 0 {
+			return fmt.Errorf("param %s: 'values' can only be used with the enum type", name)
+		}
+	case ParamEnum:
+		if len(p.Values) == 0 {
+			return fmt.Errorf("param %s: enum params need a list of 'values'", name)
+		}
+	default:
+		return fmt.Errorf("param %s: unknown type %q, expected string, bool, int or enum", name, p.Type)
+	}
+
+	if p.Default == nil {
+		return nil
+	}
+
+	switch v := p.Default.(type) {
+	case string:
+		if p.Kind() == ParamEnum && !slices.Contains(p.Values, v) {
+			return fmt.Errorf("param %s: default %q is not one of %s", name, v, strings.Join(p.Values, ", "))
+		}
+
+		if p.Kind() == ParamString || p.Kind() == ParamEnum {
+			return nil
+		}
+	case bool:
+		if p.Kind() == ParamBool {
+			return nil
+		}
+	case int64:
+		if p.Kind() == ParamInt {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("param %s: default %v does not match the type %s", name, p.Default, p.Kind())
+}
+
+// DefaultValue is the value used when the param is not set, the zero value of
+// its type unless a default is configured, enums default to the first value
+func (p Param) DefaultValue() any {
+	switch p.Kind() {
+	case ParamBool:
+		b, _ := p.Default.(bool)
+		return b
+	case ParamInt:
+		i, _ := p.Default.(int64)
+		return int(i)
+	case ParamEnum:
+		if s, ok := p.Default.(string); ok {
+			return s
+		}
+
+		if len(p.Values) > 0 {
+			return p.Values[0]
+		}
+
+		return ""
+	default:
+		s, _ := p.Default.(string)
+		return s
+	}
+}
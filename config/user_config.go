@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/BurntSushi/toml"
+)
+
+// UserCfg holds machine wide settings from <user config dir>/krill/config.toml,
+// they take precedence over the project config
+type UserCfg struct {
+	Cache CacheConfig `toml:"cache,omitempty"`
+}
+
+func UserConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "krill", "config.toml"), nil
+}
+
+func GetUserConfig() (UserCfg, error) {
+	path, err := UserConfigPath()
+	if err != nil {
+		return UserCfg{}, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return UserCfg{}, nil
+	}
+
+	if err != nil {
+		return UserCfg{}, fmt.Errorf("error reading user config: %w", err)
+	}
+
+	var cfg UserCfg
+	if err := toml.Unmarshal(b, &cfg); err != nil {
+		return UserCfg{}, fmt.Errorf("error unmarshaling user config %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// ResolveCacheConfig merges the cache settings of a project with the user
+// config and the KRILL_CACHE_DIR, KRILL_CACHE_REMOTE and KRILL_CACHE_READ_ONLY
+// environment variables, later sources override earlier ones
+func ResolveCacheConfig(project CacheConfig) (CacheConfig, error) {
+	user, err := GetUserConfig()
+	if err != nil {
+		return project, err
+	}
+
+	res := project
+	mergeCacheConfig(&res, user.Cache)
+
+	env := CacheConfig{
+		Dir:    os.Getenv("KRILL_CACHE_DIR"),
+		Remote: os.Getenv("KRILL_CACHE_REMOTE"),
+	}
+
+	if v := os.Getenv("KRILL_CACHE_READ_ONLY"); v != "" {
+		readOnly, err := strconv.ParseBool(v)
+		if err != nil {
+			return res, fmt.Errorf("invalid KRILL_CACHE_READ_ONLY value %q", v)
+		}
+
+		env.ReadOnly = &readOnly
+	}
+
+	mergeCacheConfig(&res, env)
+	return res, nil
+}
+
+func mergeCacheConfig(dst *CacheConfig, src CacheConfig) {
+	if src.Dir != "" {
+		dst.Dir = src.Dir
+	}
+
+	if src.Remote != "" {
+		dst.Remote = src.Remote
+	}
+
+	if src.ReadOnly != nil {
+		dst.ReadOnly = src.ReadOnly
+	}
+}
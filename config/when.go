@@ -0,0 +1,311 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// WhenContext is what `when` conditions of commands are evaluated against
+type WhenContext struct {
+	OS   string
+	Arch string
+	CI   bool
+	Env  func(string) string
+}
+
+func CurrentWhenContext(env func(string) string) WhenContext {
+	if env == nil {
+		env = os.Getenv
+	}
+
+	return WhenContext{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+		CI:   DetectCI(env),
+		Env:  env,
+	}
+}
+
+var ciVariables = []string{"GITHUB_ACTIONS", "GITLAB_CI", "BUILDKITE", "CIRCLECI", "TF_BUILD", "JENKINS_URL", "TEAMCITY_VERSION", "TRAVIS"}
+
+// DetectCI reports whether krill runs in a CI system, using the CI variable
+// most systems set, or variables specific to the popular ones
+func DetectCI(env func(string) string) bool {
+	if ci := strings.ToLower(env("CI")); ci != "" {
+		return ci != "false" && ci != "0"
+	}
+
+	for _, name := range ciVariables {
+		if env(name) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// EvalWhen evaluates a command condition. Conditions compare the values os,
+// arch, ci and env.NAME with string literals, and combine them with &&, ||,
+// ! and parentheses:
+//
+//	when = 'os == "linux" && !ci'
+//	when = 'env.RELEASE != "" || arch == "arm64"'
+//
+// Values on their own are true when they are not empty, or for ci when krill
+// runs in CI.
+func EvalWhen(expr string, ctx WhenContext) (bool, error) {
+	p := &whenParser{src: expr, ctx: ctx}
+	if err := p.tokenize(); err != nil {
+		return false, fmt.Errorf("invalid when %q: %w", expr, err)
+	}
+
+	v, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("invalid when %q: %w", expr, err)
+	}
+
+	return v.truthy(), nil
+}
+
+// ValidateWhen checks the syntax of a condition without depending on the
+// current platform
+func ValidateWhen(expr string) error {
+	_, err := EvalWhen(expr, WhenContext{Env: func(string) string { return "" }})
+	return err
+}
+
+type whenToken struct {
+	kind string // ident, string or the operator itself
+	text string
+}
+
+type whenValue struct {
+	s      string
+	b      bool
+	isBool bool
+}
+
+func (v whenValue) truthy() bool {
+	if v.isBool {
+		return v.b
+	}
+
+	return v.s != ""
+}
+
+func (v whenValue) String() string {
+	if v.isBool {
+		return fmt.Sprint(v.b)
+	}
+
+	return v.s
+}
+
+type whenParser struct {
+	src    string
+	ctx    WhenContext
+	tokens []whenToken
+	pos    int
+}
+
+func (p *whenParser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.HasPrefix(s[i:], "&&"), strings.HasPrefix(s[i:], "||"),
+			strings.HasPrefix(s[i:], "=="), strings.HasPrefix(s[i:], "!="):
+			p.tokens = append(p.tokens, whenToken{kind: s[i : i+2], text: s[i : i+2]})
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			p.tokens = append(p.tokens, whenToken{kind: string(c), text: string(c)})
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return fmt.Errorf("unterminated string")
+			}
+
+			p.tokens = append(p.tokens, whenToken{kind: "string", text: s[i+1 : i+1+end]})
+			i += end + 2
+		case c == '_' || unicode.IsLetter(c):
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] == '.' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+
+			p.tokens = append(p.tokens, whenToken{kind: "ident", text: s[i:j]})
+			i = j
+		default:
+			return fmt.Errorf("unexpected character %q", c)
+		}
+	}
+
+	return nil
+}
+
+func (p *whenParser) peek(kind string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind
+}
+
+func (p *whenParser) or() (whenValue, error) {
+	left, err := p.and()
+	if err != nil {
+		return left, err
+	}
+
+	for p.peek("||") {
+		p.pos++
+		right, err := p.and()
+		if err != nil {
+			return right, err
+		}
+
+		left = whenValue{b: left.truthy() || right.truthy(), isBool: true}
+	}
+
+	return left, nil
+}
+
+func (p *whenParser) and() (whenValue, error) {
+	left, err := p.unary()
+	if err != nil {
+		return left, err
+	}
+
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return right, err
+		}
+
+		left = whenValue{b: left.truthy() && right.truthy(), isBool: true}
+	}
+
+	return left, nil
+}
+
+func (p *whenParser) unary() (whenValue, error) {
+	if p.peek("!") {
+		p.pos++
+		v, err := p.unary()
+		return whenValue{b: !v.truthy(), isBool: true}, err
+	}
+
+	return p.comparison()
+}
+
+func (p *whenParser) comparison() (whenValue, error) {
+	left, err := p.primary()
+	if err != nil {
+		return left, err
+	}
+
+	if p.peek("==") || p.peek("!=") {
+		op := p.tokens[p.pos].kind
+		p.pos++
+		right, err := p.primary()
+		if err != nil {
+			return right, err
+		}
+
+		equal := left.String() == right.String()
+		return whenValue{b: equal == (op == "=="), isBool: true}, nil
+	}
+
+	return left, nil
+}
+
+func (p *whenParser) primary() (whenValue, error) {
+	if p.pos >= len(p.tokens) {
+		return whenValue{}, fmt.Errorf("unexpected end of condition")
+	}
+
+	t := p.tokens[p.pos]
+	p.pos++
+
+	switch t.kind {
+	case "(":
+		v, err := p.or()
+		if err != nil {
+			return v, err
+		}
+
+		if !p.peek(")") {
+			return v, fmt.Errorf("missing )")
+		}
+
+		p.pos++
+		return v, nil
+	case "string":
+		return whenValue{s: t.text}, nil
+	case "ident":
+		return p.ident(t.text)
+	default:
+		return whenValue{}, fmt.Errorf("unexpected %q", t.text)
+	}
+}
+
+func (p *whenParser) ident(name string) (whenValue, error) {
+	switch name {
+	case "os":
+		return whenValue{s: p.ctx.OS}, nil
+	case "arch":
+		return whenValue{s: p.ctx.Arch}, nil
+	case "ci":
+		return whenValue{b: p.ctx.CI, isBool: true}, nil
+	case "true", "false":
+		return whenValue{b: name == "true", isBool: true}, nil
+	}
+
+	if v, ok := strings.CutPrefix(name, "env."); ok && v != "" {
+		return whenValue{s: p.ctx.Env(v)}, nil
+	}
+
+	return whenValue{}, fmt.Errorf("unknown value %q, expected os, arch, ci or env.NAME", name)
+}
+
+// SupportedOn reports whether the target can run on the given platform,
+// "macos" is accepted as an alias of darwin
+func (t BuildTarget) SupportedOn(goos, goarch string) bool {
+	if len(t.Platforms) > 0 && !slices.ContainsFunc(t.Platforms, func(p string) bool {
+		return strings.EqualFold(p, goos) || (goos == "darwin" && strings.EqualFold(p, "macos"))
+	}) {
+		return false
+	}
+
+	if len(t.Arch) > 0 && !slices.ContainsFunc(t.Arch, func(a string) bool { return strings.EqualFold(a, goarch) }) {
+		return false
+	}
+
+	return true
+}
+
+func (t BuildTarget) Supported() bool {
+	return t.SupportedOn(runtime.GOOS, runtime.GOARCH)
+}
+
+// PlatformDescription describes where a target can run, for messages
+func (t BuildTarget) PlatformDescription() string {
+	var parts []string
+	if len(t.Platforms) > 0 {
+		parts = append(parts, strings.Join(t.Platforms, ", "))
+	}
+
+	if len(t.Arch) > 0 {
+		parts = append(parts, strings.Join(t.Arch, ", "))
+	}
+
+	return strings.Join(parts, " on ")
+}
@@ -0,0 +1,121 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalWhen(t *testing.T) {
+	ctx := WhenContext{
+		OS:   "linux",
+		Arch: "amd64",
+		CI:   true,
+		Env: func(key string) string {
+			return map[string]string{"RELEASE": "1", "MODE": "debug"}[key]
+		},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`os == "linux"`, true},
+		{`os != "linux"`, false},
+		{`os == 'windows'`, false},
+		{`arch == "amd64"`, true},
+		{`ci`, true},
+		{`!ci`, false},
+		{`true`, true},
+		{`false`, false},
+
+		// values on their own are true when not empty
+		{`env.RELEASE`, true},
+		{`env.MISSING`, false},
+		{`env.MISSING == ""`, true},
+		{`env.MODE == "debug"`, true},
+
+		// && binds tighter than ||
+		{`true || false && false`, true},
+		{`false && true || true`, true},
+		{`(true || false) && false`, false},
+
+		// ! applies to a whole comparison, but not to && or ||
+		{`!os == "windows"`, true},
+		{`!os == "linux"`, false},
+		{`!ci && os == "linux"`, false},
+		{`!ci || os == "linux"`, true},
+		{`!(os == "windows")`, true},
+		{`!!ci`, true},
+		{`!env.MISSING`, true},
+
+		// quotes keep operators and spaces
+		{`env.MODE != "a && b"`, true},
+		{`"a || b" == 'a || b'`, true},
+		{`"" == ''`, true},
+
+		{`os == "linux" && (arch == "arm64" || env.RELEASE != "")`, true},
+		{`  os   ==   "linux"  `, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			got, err := EvalWhen(tt.expr, ctx)
+			if err != nil {
+				t.Fatalf("EvalWhen() error = %v", err)
+			}
+
+			if got != tt.want {
+				t.Errorf("EvalWhen() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalWhenErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		err  string
+	}{
+		{``, "unexpected end of condition"},
+		{`os ==`, "unexpected end of condition"},
+		{`os == "linux`, "unterminated string"},
+		{`(os == "linux"`, "missing )"},
+		{`os == "linux")`, `unexpected ")"`},
+		{`os = "linux"`, "unexpected character '='"},
+		{`platform == "linux"`, `unknown value "platform"`},
+		{`env. == ""`, `unknown value "env."`},
+		{`&& ci`, `unexpected "&&"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			err := ValidateWhen(tt.expr)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ValidateWhen() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDetectCI(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want bool
+	}{
+		{"nothing set", nil, false},
+		{"CI true", map[string]string{"CI": "true"}, true},
+		{"CI false", map[string]string{"CI": "false"}, false},
+		{"CI 0", map[string]string{"CI": "0"}, false},
+		{"CI false wins over system variables", map[string]string{"CI": "false", "GITHUB_ACTIONS": "true"}, false},
+		{"system variable", map[string]string{"GITLAB_CI": "true"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCI(func(key string) string { return tt.env[key] }); got != tt.want {
+				t.Errorf("DetectCI() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,19 +8,6 @@ import (
 	"github.com/kociumba/krill/config"
 )
 
-var excludeDirs = map[string]struct{}{
-	".git":                {},
-	"node_modules":        {},
-	"vendor":              {},
-	"target":              {},
-	"bin":                 {},
-	"build":               {},
-	"cmake-build-debug":   {},
-	"cmake-build-release": {},
-	"meson-build-debug":   {},
-	"meson-build-release": {},
-}
-
 func DetectNestedProjects(root string) (map[string]config.NestedProject, error) {
 	nested := make(map[string]config.NestedProject)
 
@@ -30,7 +17,7 @@ func DetectNestedProjects(root string) (map[string]config.NestedProject, error)
 		}
 
 		if d.IsDir() {
-			if _, exclude := excludeDirs[d.Name()]; exclude {
+			if _, exclude := config.ExcludeDirs[d.Name()]; exclude {
 				return filepath.SkipDir
 			}
 
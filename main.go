@@ -2,17 +2,22 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/kociumba/krill/build"
+	"github.com/kociumba/krill/cache"
 	"github.com/kociumba/krill/cli_utils"
 	"github.com/kociumba/krill/config"
 	"github.com/kociumba/krill/git"
 	"github.com/kociumba/krill/integration"
+	"github.com/kociumba/krill/telemetry"
 	"github.com/kociumba/krill/templating"
 	"github.com/urfave/cli/v3"
 )
@@ -75,9 +80,10 @@ var cmds = []*cli.Command{
 		},
 	},
 	{
-		Name:     "run",
-		Usage:    "run targets defined in the config file",
-		HideHelp: true,
+		Name:      "run",
+		Usage:     "run targets defined in the config file",
+		ArgsUsage: "[targets...] [-- args]",
+		HideHelp:  true,
 	},
 	{
 		Name:  "status",
@@ -117,6 +123,292 @@ var cmds = []*cli.Command{
 			return nil
 		},
 	},
+	{
+		Name:      "graph",
+		Usage:     "Print the target dependency graph, including nested projects",
+		ArgsUsage: "[target]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "format",
+				Aliases: []string{"f"},
+				Value:   "dot",
+				Usage:   "Output format: dot, mermaid or json",
+			},
+			&cli.StringFlag{
+				Name:  "reverse",
+				Usage: "Only show the given target and everything that depends on it",
+			},
+			&cli.BoolFlag{
+				Name:  "path",
+				Usage: "Only show the dependency paths between two targets: krill graph --path <from> <to>",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if !config.HasConfig {
+				return fmt.Errorf("'krill graph' is not supproted without a config, use 'krill init' first")
+			}
+
+			opts := build.GraphOptions{
+				Format:  c.String("format"),
+				Reverse: c.String("reverse"),
+			}
+
+			if c.Bool("path") {
+				opts.Path = c.Args().Slice()
+			} else {
+				opts.Target = c.Args().First()
+			}
+
+			return build.WriteGraph(os.Stdout, &config.CFG, opts)
+		},
+	},
+	{
+		Name:  "targets",
+		Usage: "List the targets of the project, with their descriptions and dependencies",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "json",
+				Usage: "Print the targets as json",
+			},
+			&cli.BoolFlag{
+				Name:    "all",
+				Aliases: []string{"a"},
+				Usage:   "Also list hidden targets",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if !config.HasConfig {
+				return fmt.Errorf("'krill targets' is not supproted without a config, use 'krill init' first")
+			}
+
+			return build.ListTargets(&config.CFG, build.TargetsOptions{
+				JSON: c.Bool("json"),
+				All:  c.Bool("all"),
+			})
+		},
+	},
+	{
+		Name:      "clean",
+		Usage:     "Remove the output directories and outputs of targets, including nested projects",
+		ArgsUsage: "[target...]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "dry-run",
+				Usage: "Only list what would be removed and which clean commands would run",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if !config.HasConfig {
+				return fmt.Errorf("'krill clean' is not supproted without a config, use 'krill init' first")
+			}
+
+			return build.Clean(ctx, &config.CFG, build.CleanOptions{
+				Targets: c.Args().Slice(),
+				DryRun:  c.Bool("dry-run"),
+			})
+		},
+	},
+	{
+		Name:      "package",
+		Usage:     "Build a target and archive its artifacts as reproducible .tar.gz and .zip files with checksums",
+		ArgsUsage: "<target>",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "out",
+				Value: "dist",
+				Usage: "Directory the archives and checksums.txt are written to",
+			},
+			&cli.StringSliceFlag{
+				Name:  "format",
+				Value: build.PackageFormats,
+				Usage: "Archive formats to create: tar.gz, zip",
+			},
+			&cli.BoolFlag{
+				Name:  "skip-build",
+				Usage: "Package the artifacts as they are, without building the target first",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			if !config.HasConfig {
+				return fmt.Errorf("'krill package' is not supproted without a config, use 'krill init' first")
+			}
+
+			if c.Args().Len() != 1 {
+				return fmt.Errorf("expected exactly one target to package")
+			}
+
+			return build.Package(ctx, &config.CFG, build.PackageOptions{
+				Target:    c.Args().First(),
+				OutDir:    c.String("out"),
+				Formats:   c.StringSlice("format"),
+				SkipBuild: c.Bool("skip-build"),
+			})
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "verify",
+				Usage:     "Verify the archives in a directory against its checksums.txt",
+				ArgsUsage: "[dir]",
+				Action: func(ctx context.Context, c *cli.Command) error {
+					dir := c.Args().First()
+					if dir == "" {
+						dir = "dist"
+					}
+
+					return build.VerifyPackages(dir)
+				},
+			},
+		},
+	},
+	{
+		Name:      "logs",
+		Usage:     "Show the output of previous runs, recorded in .krill/logs",
+		ArgsUsage: "[target]",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "run",
+				Usage: "Id of the run to show, defaults to the latest run",
+			},
+			&cli.BoolFlag{
+				Name:  "failed",
+				Usage: "Print the output of every failed target of the run",
+			},
+			&cli.BoolFlag{
+				Name:    "list",
+				Aliases: []string{"l"},
+				Usage:   "List all recorded runs",
+			},
+		},
+		Action: func(ctx context.Context, c *cli.Command) error {
+			wd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+
+			return build.ShowLogs(wd, build.LogsOptions{
+				Run:    c.String("run"),
+				Target: c.Args().First(),
+				Failed: c.Bool("failed"),
+				List:   c.Bool("list"),
+			})
+		},
+	},
+	{
+		Name:  "cache",
+		Usage: "Inspect and manage the local build cache",
+		Commands: []*cli.Command{
+			{
+				Name:  "stats",
+				Usage: "Show the location, size and number of entries of the build cache",
+				Action: func(ctx context.Context, c *cli.Command) error {
+					store, err := openLocalCache()
+					if err != nil {
+						return err
+					}
+
+					stats, err := store.Stats()
+					if err != nil {
+						return err
+					}
+
+					fmt.Printf("location: %s\n", store.Dir())
+					fmt.Printf("entries:  %d\n", stats.Entries)
+					fmt.Printf("blobs:    %d (%s)\n", stats.Blobs, cache.FormatSize(stats.BlobBytes))
+					if stats.Entries > 0 {
+						fmt.Printf("oldest:   %s\n", stats.Oldest.Format(time.DateTime))
+						fmt.Printf("newest:   %s\n", stats.Newest.Format(time.DateTime))
+					}
+
+					return nil
+				},
+			},
+			{
+				Name:  "prune",
+				Usage: "Remove the least recently used cache entries until the cache fits in --max-size, or every entry with --all",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "max-size",
+						Usage: "Maximum size of the cache after pruning, e.g. 500M or 10G",
+					},
+					&cli.BoolFlag{
+						Name:  "all",
+						Usage: "Remove every entry of the cache",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					var maxSize int64
+					switch {
+					case c.IsSet("max-size") && c.Bool("all"):
+						return fmt.Errorf("--max-size and --all can not be used together")
+					case c.IsSet("max-size"):
+						var err error
+						maxSize, err = cache.ParseSize(c.String("max-size"))
+						if err != nil {
+							return err
+						}
+					case !c.Bool("all"):
+						return fmt.Errorf("either --max-size or --all is required")
+					}
+
+					store, err := openLocalCache()
+					if err != nil {
+						return err
+					}
+
+					res, err := store.Prune(maxSize)
+					if err != nil {
+						return err
+					}
+
+					cli_utils.PrintSuccessMessage(fmt.Sprintf(
+						"removed %d entries and %d blobs, freed %s",
+						res.RemovedEntries, res.RemovedBlobs, cache.FormatSize(res.FreedBytes),
+					))
+
+					return nil
+				},
+			},
+			{
+				Name:  "serve",
+				Usage: "Serve the local cache over HTTP, as a remote cache for other machines or for testing",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Value: "127.0.0.1:8090",
+						Usage: "Address to listen on",
+					},
+					&cli.StringFlag{
+						Name:  "dir",
+						Usage: "Directory to store the served cache in, defaults to the local cache",
+					},
+					&cli.StringFlag{
+						Name:    "token",
+						Usage:   "Require this bearer token for uploads",
+						Sources: cli.EnvVars("KRILL_CACHE_TOKEN"),
+					},
+					&cli.BoolFlag{
+						Name:  "read-only",
+						Usage: "Reject all uploads",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					var store *cache.Local
+					if c.String("dir") != "" {
+						store, err = cache.Open(c.String("dir"))
+					} else {
+						store, err = openLocalCache()
+					}
+
+					if err != nil {
+						return err
+					}
+
+					fmt.Printf("Serving cache %s on http://%s\n", store.Dir(), c.String("addr"))
+					return http.ListenAndServe(c.String("addr"), cache.NewServer(store, c.String("token"), c.Bool("read-only")))
+				},
+			},
+		},
+	},
 	{
 		Name:  "debug",
 		Usage: "All debugging utilities are groupped under this subcommand",
@@ -140,6 +432,22 @@ var cmds = []*cli.Command{
 					return nil
 				},
 			},
+			{
+				Name:  "otel-collector",
+				Usage: "Run a minimal OTLP/HTTP collector that prints the spans exported by 'krill run'",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "addr",
+						Value: "localhost:4318",
+						Usage: "Address to listen on",
+					},
+				},
+				Action: func(ctx context.Context, c *cli.Command) error {
+					fmt.Printf("Listening for traces on http://%s/v1/traces\n", c.String("addr"))
+					fmt.Printf("Use OTEL_EXPORTER_OTLP_ENDPOINT=http://%s krill run <target>\n", c.String("addr"))
+					return http.ListenAndServe(c.String("addr"), telemetry.NewCollector(os.Stdout))
+				},
+			},
 			{
 				Name:  "random-cfg",
 				Usage: "Creates, marshals and prints a full config file with randomized data",
@@ -238,6 +546,15 @@ func getProjectInfo() (string, string, error) {
 	return projectName, version.StringV(), nil
 }
 
+func openLocalCache() (*cache.Local, error) {
+	cacheCfg, err := config.ResolveCacheConfig(config.CFG.Cache)
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.Open(cacheCfg.Dir)
+}
+
 var err error
 
 func main() {
@@ -252,8 +569,17 @@ func main() {
 			log.Fatalf("could not expand templating arguments in config: %s", err)
 		}
 
-		build_cmds = build.GenerateBuildCmds(config.CFG)
-		if len(build_cmds) > 0 {
+		build_cmds, err = build.GenerateBuildCmds(config.CFG)
+		if err != nil {
+			targetsErr := err
+			build_action = func(ctx context.Context, cmd *cli.Command) error {
+				return fmt.Errorf("invalid targets in config: %w", targetsErr)
+			}
+		} else {
+			// runs globs, tags or the default target, or lists the targets
+			build_action = func(ctx context.Context, cmd *cli.Command) error {
+				return build.RunTargets(ctx, cmd, config.CFG, "")
+			}
 		}
 	} else {
 		build_action = func(ctx context.Context, cmd *cli.Command) error {
@@ -264,12 +590,9 @@ func main() {
 
 	for _, c := range cmds {
 		if c.Name == "run" {
-			if build_cmds != nil {
-				c.Commands = build_cmds
-				c.DefaultCommand = build_cmds[0].Name
-			} else {
-				c.Action = build_action
-			}
+			c.Flags = build.RunFlags
+			c.Commands = build_cmds
+			c.Action = build_action
 		}
 	}
 
@@ -301,7 +624,16 @@ func main() {
 
 	cli.RootCommandHelpTemplate = fmt.Sprintf("%s\nDOCS: https://kociumba.github.io/krill", cli.RootCommandHelpTemplate)
 
-	if err := cmd.Run(context.Background(), os.Args); err != nil {
+	ctx, stop := build.HandleSignals(context.Background())
+	err = cmd.Run(ctx, os.Args)
+	stop()
+
+	if errors.Is(err, build.ErrInterrupted) {
+		cli_utils.PrintWarningMessage(fmt.Sprintf("Interrupted, %s", err))
+		os.Exit(130)
+	}
+
+	if err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,104 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collector is a minimal stand-in for an OTLP/HTTP collector, it accepts
+// JSON encoded traces on /v1/traces and prints every received span as a tree
+type Collector struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewCollector(out io.Writer) *Collector {
+	return &Collector{out: out}
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "only the JSON encoding is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.print(ss.Spans)
+		}
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "{}")
+}
+
+func (c *Collector) print(spans []SpanData) {
+	children := make(map[string][]SpanData)
+	ids := make(map[string]bool, len(spans))
+	for _, s := range spans {
+		ids[s.SpanID] = true
+	}
+
+	var roots []SpanData
+	for _, s := range spans {
+		if s.ParentSpanID == "" || !ids[s.ParentSpanID] {
+			roots = append(roots, s)
+		} else {
+			children[s.ParentSpanID] = append(children[s.ParentSpanID], s)
+		}
+	}
+
+	var walk func(s SpanData, depth int)
+	walk = func(s SpanData, depth int) {
+		start, _ := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+		end, _ := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+
+		status := ""
+		if s.Status.Code == statusError {
+			status = " ERROR " + s.Status.Message
+		}
+
+		fmt.Fprintf(c.out, "%s%s (%s)%s\n", strings.Repeat("  ", depth), s.Name, time.Duration(end-start).Round(time.Microsecond), status)
+		for _, a := range s.Attributes {
+			fmt.Fprintf(c.out, "%s  %s=%s\n", strings.Repeat("  ", depth), a.Key, a.Value)
+		}
+
+		for _, l := range s.Links {
+			fmt.Fprintf(c.out, "%s  link=%s\n", strings.Repeat("  ", depth), l.SpanID)
+		}
+
+		for _, child := range children[s.SpanID] {
+			walk(child, depth+1)
+		}
+	}
+
+	for _, s := range roots {
+		fmt.Fprintf(c.out, "trace %s\n", s.TraceID)
+		walk(s, 0)
+	}
+}
@@ -0,0 +1,156 @@
+package telemetry
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// the types below are the subset of the OTLP JSON encoding krill produces,
+// see opentelemetry-proto/opentelemetry/proto/trace/v1/trace.proto
+
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []SpanData `json:"spans"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+type SpanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Links             []Link     `json:"links,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+type Link struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type Status struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	statusOK         = 1
+	statusError      = 2
+)
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // int64 values are strings in OTLP JSON
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return strconv.Quote(*v.StringValue)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	default:
+		return "<empty>"
+	}
+}
+
+func encode(service string, spans []Span) ExportRequest {
+	data := make([]SpanData, len(spans))
+	for i, s := range spans {
+		d := SpanData{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: unixNano(s.Start),
+			EndTimeUnixNano:   unixNano(s.End),
+			Attributes:        attributes(s.Attrs),
+			Status:            Status{Code: statusOK},
+		}
+
+		for _, id := range s.Links {
+			d.Links = append(d.Links, Link{TraceID: s.TraceID, SpanID: id})
+		}
+
+		if s.Error != "" {
+			d.Status = Status{Code: statusError, Message: s.Error}
+		}
+
+		data[i] = d
+	}
+
+	return ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   Resource{Attributes: attributes(map[string]any{"service.name": service})},
+		ScopeSpans: []ScopeSpans{{Scope: Scope{Name: "krill"}, Spans: data}},
+	}}}
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func attributes(attrs map[string]any) []KeyValue {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	kvs := make([]KeyValue, 0, len(attrs))
+	for _, k := range keys {
+		var v AnyValue
+		switch a := attrs[k].(type) {
+		case string:
+			v.StringValue = &a
+		case bool:
+			v.BoolValue = &a
+		case int:
+			s := strconv.Itoa(a)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &a
+		default:
+			s := fmt.Sprint(a)
+			v.StringValue = &s
+		}
+
+		kvs = append(kvs, KeyValue{Key: k, Value: v})
+	}
+
+	return kvs
+}
@@ -0,0 +1,192 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Span is a finished span, ids are hex encoded as in the OTLP JSON encoding
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]any // string, bool, int or float64 values
+	Links    []string       // span ids in the same trace
+	Error    string         // sets the span status to error when not empty
+}
+
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Exporter sends spans to an OTLP/HTTP endpoint using the JSON encoding
+type Exporter struct {
+	Endpoint string
+	Headers  map[string]string
+	Service  string
+	Timeout  time.Duration
+	client   *http.Client
+}
+
+// FromEnv configures an exporter from the standard OTEL_* environment
+// variables, ok is false if no endpoint is set or tracing is disabled
+func FromEnv() (exp *Exporter, ok bool, err error) {
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") || os.Getenv("OTEL_TRACES_EXPORTER") == "none" {
+		return nil, false, nil
+	}
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, false, nil
+		}
+
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+
+	// OTLP/HTTP receivers accept both encodings, so spans are always sent as
+	// JSON, only grpc can not be supported without a grpc client
+	protocol := firstEnv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL")
+	if protocol != "" && protocol != "http/json" && protocol != "http/protobuf" {
+		return nil, false, fmt.Errorf("unsupported OTLP protocol %q, krill only exports over http", protocol)
+	}
+
+	headers, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return nil, false, err
+	}
+
+	traceHeaders, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_TRACES_HEADERS"))
+	if err != nil {
+		return nil, false, err
+	}
+
+	for k, v := range traceHeaders {
+		headers[k] = v
+	}
+
+	timeout := 10 * time.Second
+	if ms := firstEnv("OTEL_EXPORTER_OTLP_TRACES_TIMEOUT", "OTEL_EXPORTER_OTLP_TIMEOUT"); ms != "" {
+		n, err := strconv.Atoi(ms)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid OTLP timeout %q: %w", ms, err)
+		}
+
+		timeout = time.Duration(n) * time.Millisecond
+	}
+
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = "krill"
+	}
+
+	return &Exporter{
+		Endpoint: endpoint,
+		Headers:  headers,
+		Service:  service,
+		Timeout:  timeout,
+	}, true, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// parseHeaders parses the W3C baggage like "key1=value1,key2=value2" format
+// used by OTEL_EXPORTER_OTLP_HEADERS
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid OTLP header %q, expected key=value", pair)
+		}
+
+		value, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP header %q: %w", pair, err)
+		}
+
+		headers[strings.TrimSpace(k)] = value
+	}
+
+	return headers, nil
+}
+
+// Export sends all spans in a single request
+func (e *Exporter) Export(ctx context.Context, spans []Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(encode(e.Service, spans))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := e.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", e.Endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
@@ -2,8 +2,11 @@ package templating
 
 import (
 	"fmt"
+	"maps"
 	"os"
-	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
 	"strings"
 	"text/template"
 
@@ -12,19 +15,39 @@ import (
 )
 
 func ExpandConfig(cfg config.Cfg) (config.Cfg, error) {
+	return ExpandConfigWith(cfg, "", "", nil)
+}
+
+// ExpandConfigWith expands the config with the arguments and param values
+// given to 'krill run' for target, params that are not set use their defaults
+func ExpandConfigWith(cfg config.Cfg, args, target string, params map[string]any) (config.Cfg, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		return config.Cfg{}, fmt.Errorf("failed to get working directory: %w", err)
 	}
 
-	filePath := filepath.Join(wd, "krill.toml")
+	return ExpandConfigAt(wd, cfg, args, target, params)
+}
+
+// ExpandConfigAt expands the config of the project in dir, used for nested
+// projects which are never the working directory
+func ExpandConfigAt(dir string, cfg config.Cfg, args, target string, params map[string]any) (config.Cfg, error) {
+	// inherited values have to be in the template data as well
+	cfg, err := config.ResolveExtends(cfg)
+	if err != nil {
+		return config.Cfg{}, err
+	}
+
+	filePath := config.ConfigPath(dir)
 	fileContent, err := os.ReadFile(filePath)
 	if err != nil {
 		return config.Cfg{}, fmt.Errorf("failed to read krill.toml: %w", err)
 	}
 
 	if !strings.Contains(string(fileContent), "{{") {
-		return cfg, nil
+		return expandMatrices(cfg, func(string, map[string]string) (config.Cfg, error) {
+			return cfg, nil
+		})
 	}
 
 	templateData, err := resolveTags(cfg)
@@ -41,22 +64,187 @@ func ExpandConfig(cfg config.Cfg) (config.Cfg, error) {
 		templateData["framework_ext"] = config.BinaryTypeToExt[config.Framework]
 	}
 
-	tmpl, err := template.New("config").Parse(string(fileContent))
+	templateData["args"] = templateString(args)
+
+	// params are inserted as they are, quote makes a value safe to use as a
+	// single argument of the configured shell, like every one of .args
+	shell := cfg.Env[runtime.GOOS].Path
+	funcs := template.FuncMap{
+		"quote": func(v any) any {
+			s, ok := v.(commandValue)
+			if !ok {
+				s = commandValue(fmt.Sprint(v))
+			}
+
+			return templateString(config.QuoteArg(shell, string(s)))
+		},
+	}
+
+	tmpl, err := template.New("config").Funcs(funcs).Parse(string(fileContent))
 	if err != nil {
 		return config.Cfg{}, fmt.Errorf("failed to parse template: %w", err)
 	}
 
-	var sb strings.Builder
-	err = tmpl.Execute(&sb, templateData)
-	if err != nil {
-		return config.Cfg{}, fmt.Errorf("failed to execute template: %w", err)
+	// the file is rendered with .params set to the params of a single target,
+	// once more for every combination of a matrix, with .matrix set to its
+	// values
+	render := func(params map[string]any, matrix map[string]string) (config.Cfg, error) {
+		templateData["params"] = params
+		templateData["matrix"] = matrix
+
+		var sb strings.Builder
+		err := tmpl.Execute(&sb, templateData)
+		if err != nil {
+			return config.Cfg{}, fmt.Errorf("failed to execute template: %w", err)
+		}
+
+		var newCfg config.Cfg
+		err = toml.Unmarshal([]byte(sb.String()), &newCfg)
+		if err != nil {
+			return config.Cfg{}, fmt.Errorf("failed to unmarshal rendered TOML: %w", err)
+		}
+
+		substitute(reflect.ValueOf(&newCfg))
+		return config.ResolveExtends(newCfg)
 	}
 
-	var newCfg config.Cfg
-	err = toml.Unmarshal([]byte(sb.String()), &newCfg)
+	targetParams := func(name string) map[string]any {
+		var set map[string]any
+		if name == target {
+			set = params
+		}
+
+		return paramValues(cfg.BuildTargets[name].Params, set)
+	}
+
+	newCfg, err := render(defaultParams(cfg), emptyMatrix(cfg))
 	if err != nil {
-		return config.Cfg{}, fmt.Errorf("failed to unmarshal rendered TOML: %w", err)
+		return config.Cfg{}, err
+	}
+
+	// targets with params are taken from a render with their own values, so
+	// commands inherited from a base target use the params of the child
+	for _, name := range slices.Sorted(maps.Keys(cfg.BuildTargets)) {
+		t := cfg.BuildTargets[name]
+		if len(t.Params) == 0 || !t.Matrix.IsEmpty() {
+			continue
+		}
+
+		rendered, err := render(targetParams(name), emptyMatrix(cfg))
+		if err != nil {
+			return config.Cfg{}, fmt.Errorf("target %s: %w", name, err)
+		}
+
+		if t, ok := rendered.BuildTargets[name]; ok {
+			newCfg.BuildTargets[name] = t
+		}
+	}
+
+	return expandMatrices(newCfg, func(name string, matrix map[string]string) (config.Cfg, error) {
+		return render(targetParams(name), matrix)
+	})
+}
+
+// emptyMatrix has every axis used in the config, set to an empty string, so
+// targets outside of a matrix can still be rendered
+func emptyMatrix(cfg config.Cfg) map[string]string {
+	matrix := make(map[string]string)
+	for _, target := range cfg.BuildTargets {
+		for axis := range target.Matrix.Axes {
+			matrix[axis] = ""
+		}
+	}
+
+	return matrix
+}
+
+// expandMatrices replaces every target with a matrix by one target for each
+// combination, taken from the config rendered with its values, and an
+// aggregate target depending on all of them
+func expandMatrices(cfg config.Cfg, render func(target string, matrix map[string]string) (config.Cfg, error)) (config.Cfg, error) {
+	var names []string
+	for name, target := range cfg.BuildTargets {
+		if !target.Matrix.IsEmpty() {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return cfg, nil
+	}
+
+	slices.Sort(names)
+	targets := maps.Clone(cfg.BuildTargets)
+	for _, name := range names {
+		target := targets[name]
+		aggregate := config.BuildTarget{
+			Description:     target.Description,
+			Aliases:         target.Aliases,
+			Hidden:          target.Hidden,
+			Tags:            target.Tags,
+			MatrixAggregate: true,
+		}
+
+		combos := target.Matrix.Combinations()
+		if len(combos) == 0 {
+			return config.Cfg{}, fmt.Errorf("matrix of target %s excludes every combination", name)
+		}
+
+		for _, combo := range combos {
+			rendered, err := render(name, combo)
+			if err != nil {
+				return config.Cfg{}, fmt.Errorf("matrix %s: %w", config.MatrixTargetName(name, combo), err)
+			}
+
+			comboName := config.MatrixTargetName(name, combo)
+			if _, ok := targets[comboName]; ok {
+				return config.Cfg{}, fmt.Errorf("matrix target %s is already defined", comboName)
+			}
+
+			t := rendered.BuildTargets[name]
+			t.Matrix = config.Matrix{}
+			t.Aliases = nil
+			targets[comboName] = t
+			aggregate.DependsOn = append(aggregate.DependsOn, comboName)
+		}
+
+		targets[name] = aggregate
+	}
+
+	cfg.BuildTargets = targets
+	return cfg, nil
+}
+
+// paramValues holds the values of the params of a target, the defaults
+// overridden by the ones set on the command line
+func paramValues(params map[string]config.Param, set map[string]any) map[string]any {
+	values := make(map[string]any, len(params))
+	for name, param := range params {
+		values[name] = param.DefaultValue()
+	}
+
+	maps.Copy(values, set)
+	for name, value := range values {
+		if s, ok := value.(string); ok {
+			values[name] = templateString(s)
+		}
+	}
+
+	return values
+}
+
+// defaultParams holds the defaults of the params of every target, for the
+// targets which do not declare params themselves. A param declared by several
+// targets has the default of the first one by name.
+func defaultParams(cfg config.Cfg) map[string]any {
+	values := make(map[string]any)
+	for _, name := range slices.Sorted(maps.Keys(cfg.BuildTargets)) {
+		for param, value := range paramValues(cfg.BuildTargets[name].Params, nil) {
+			if _, ok := values[param]; !ok {
+				values[param] = value
+			}
+		}
 	}
 
-	return newCfg, nil
+	return values
 }
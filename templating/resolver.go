@@ -24,6 +24,10 @@ func resolveTags(v any) (map[string]any, error) {
 		fieldName := field.Name
 		fieldValue := val.Field(i)
 
+		if tomlTag == "-" {
+			continue
+		}
+
 		if tomlTag != "" {
 			parts := strings.Split(tomlTag, ",")
 			tomlName := parts[0]
@@ -0,0 +1,70 @@
+package templating
+
+import (
+	"encoding/hex"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// placeholder is rendered into the toml source in place of a value given on
+// the command line, it is valid in every kind of toml string and replaced by
+// the value once the config is decoded
+var placeholder = regexp.MustCompile(`@@krill:([0-9a-f]*)@@`)
+
+// commandValue is a string given on the command line. Templates compare it
+// like any other string, but print it as a placeholder, so quotes and
+// backslashes in it can never break the toml source.
+type commandValue string
+
+func (v commandValue) String() string {
+	return "@@krill:" + hex.EncodeToString([]byte(v)) + "@@"
+}
+
+// templateString wraps s in a commandValue, unless it can be rendered into
+// any toml string as it is
+func templateString(s string) any {
+	if strings.ContainsFunc(s, func(c rune) bool {
+		return c == '"' || c == '\'' || c == '\\' || c < ' ' || c == 0x7f
+	}) {
+		return commandValue(s)
+	}
+
+	return s
+}
+
+// substitute replaces the placeholders in every string of v, which has to be
+// a pointer
+func substitute(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			substitute(v.Elem())
+		}
+	case reflect.String:
+		if v.CanSet() && strings.Contains(v.String(), "@@krill:") {
+			v.SetString(placeholder.ReplaceAllStringFunc(v.String(), func(m string) string {
+				b, _ := hex.DecodeString(placeholder.FindStringSubmatch(m)[1])
+				return string(b)
+			}))
+		}
+	case reflect.Struct:
+		for i := range v.NumField() {
+			if v.Type().Field(i).IsExported() {
+				substitute(v.Field(i))
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := range v.Len() {
+			substitute(v.Index(i))
+		}
+	case reflect.Map:
+		// map values are not addressable, they are replaced by a copy
+		for _, key := range v.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(v.MapIndex(key))
+			substitute(elem)
+			v.SetMapIndex(key, elem)
+		}
+	}
+}
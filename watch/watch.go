@@ -0,0 +1,174 @@
+package watch
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Skip reports whether a path, relative to the watched root and slash
+// separated, should be ignored, skipped directories are not descended into
+type Skip func(rel string, isDir bool) bool
+
+// Watcher reports changed paths, relative to the watched root
+type Watcher interface {
+	Changes() <-chan string
+	Close() error
+}
+
+const DefaultPollInterval = 500 * time.Millisecond
+
+// New watches root recursively using native filesystem notifications where
+// they are available and falls back to polling otherwise
+func New(root string, skip Skip) (Watcher, error) {
+	w, err := newNative(root, skip)
+	if err == nil {
+		return w, nil
+	}
+
+	return NewPoller(root, skip, DefaultPollInterval)
+}
+
+func relPath(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// walkDirs calls fn for every directory under root that is not skipped
+func walkDirs(root string, skip Skip, fn func(path string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+
+			return nil
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		if path != root && skip != nil && skip(relPath(root, path), true) {
+			return filepath.SkipDir
+		}
+
+		return fn(path)
+	})
+}
+
+type fileStamp struct {
+	mod  time.Time
+	size int64
+}
+
+type poller struct {
+	root     string
+	skip     Skip
+	interval time.Duration
+	changes  chan string
+	done     chan struct{}
+}
+
+func NewPoller(root string, skip Skip, interval time.Duration) (Watcher, error) {
+	p := &poller{
+		root:     root,
+		skip:     skip,
+		interval: interval,
+		changes:  make(chan string, 64),
+		done:     make(chan struct{}),
+	}
+
+	prev, err := p.scan()
+	if err != nil {
+		return nil, err
+	}
+
+	go p.loop(prev)
+	return p, nil
+}
+
+func (p *poller) Changes() <-chan string {
+	return p.changes
+}
+
+func (p *poller) Close() error {
+	close(p.done)
+	return nil
+}
+
+func (p *poller) loop(prev map[string]fileStamp) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		cur, err := p.scan()
+		if err != nil {
+			continue
+		}
+
+		for path, stamp := range cur {
+			if old, ok := prev[path]; !ok || old != stamp {
+				p.emit(path)
+			}
+		}
+
+		for path := range prev {
+			if _, ok := cur[path]; !ok {
+				p.emit(path)
+			}
+		}
+
+		prev = cur
+	}
+}
+
+func (p *poller) emit(path string) {
+	select {
+	case p.changes <- path:
+	case <-p.done:
+	}
+}
+
+func (p *poller) scan() (map[string]fileStamp, error) {
+	files := make(map[string]fileStamp)
+	err := walkDirs(p.root, p.skip, func(dir string) error {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil
+		}
+
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+
+			rel := relPath(p.root, filepath.Join(dir, e.Name()))
+			if p.skip != nil && p.skip(rel, false) {
+				continue
+			}
+
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+
+			files[rel] = fileStamp{mod: info.ModTime(), size: info.Size()}
+		}
+
+		return nil
+	})
+
+	return files, err
+}
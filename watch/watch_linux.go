@@ -0,0 +1,138 @@
+//go:build linux
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+type inotify struct {
+	root    string
+	skip    Skip
+	fd      int
+	file    *os.File
+	changes chan string
+	done    chan struct{}
+
+	mu   sync.Mutex
+	dirs map[int32]string
+}
+
+func newNative(root string, skip Skip) (Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	// a non blocking fd wrapped in an os.File goes through the runtime poller,
+	// so closing the file unblocks a pending Read
+	w := &inotify{
+		root:    root,
+		skip:    skip,
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan string, 64),
+		done:    make(chan struct{}),
+		dirs:    make(map[int32]string),
+	}
+
+	if err := walkDirs(root, skip, w.add); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.loop()
+	return w, nil
+}
+
+func (w *inotify) add(dir string) error {
+	// w.file.Fd() would switch the fd back to blocking mode
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	w.dirs[int32(wd)] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *inotify) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotify) Close() error {
+	close(w.done)
+	return w.file.Close()
+}
+
+func (w *inotify) loop() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+			off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+			w.mu.Lock()
+			dir, ok := w.dirs[ev.Wd]
+			if ev.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, ev.Wd)
+			}
+			w.mu.Unlock()
+
+			if !ok {
+				continue
+			}
+
+			path := filepath.Join(dir, cString(nameBytes))
+			rel := relPath(w.root, path)
+			isDir := ev.Mask&syscall.IN_ISDIR != 0
+
+			if w.skip != nil && w.skip(rel, isDir) {
+				continue
+			}
+
+			// directories created after the watch started, watched lazily
+			if isDir && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				walkDirs(path, func(sub string, isDir bool) bool {
+					return w.skip != nil && w.skip(relPath(w.root, filepath.Join(path, sub)), isDir)
+				}, w.add)
+			}
+
+			if isDir {
+				continue
+			}
+
+			select {
+			case w.changes <- rel:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+func cString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+
+	return string(b)
+}